@@ -1,51 +1,867 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRouter() *gin.Engine {
-	r := gin.Default()
+	configureGinMode()
 
-	r.GET("/user/:address/tasks", getUserTasks)
-	r.GET("/user/:address/points", getUserPointsHistory)
-	r.GET("/ethereum/price", getEthereumPrice) // New endpoint
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	r.Use(requestID(), recovery(), requestLogger())
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, "Route not found", nil)
+	})
+	r.NoMethod(func(c *gin.Context) {
+		respondError(c, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	})
+
+	// Metrics are always served at the root for scrapers, and under API_PREFIX alongside the API
+	r.GET("/metrics", getMetrics)
+
+	prefix := apiPrefix(os.Getenv("API_PREFIX"))
+	api := r.Group(prefix)
+	if prefix != "/" {
+		api.GET("/metrics", getMetrics)
+	}
+	registerRoutes(api.Group("/v1"))
+	// Unversioned paths are deprecated aliases of /v1, kept for one release while clients migrate
+	registerRoutes(api.Group("/", deprecated()))
 
 	return r
 }
 
+func registerRoutes(api *gin.RouterGroup) {
+	api.GET("/ready", getReady)
+	api.GET("/user/:address/tasks", getUserTasks)
+	api.GET("/user/:address/points", getUserPointsHistory)
+	api.GET("/user/:address/summary", getUserSummary)
+	api.GET("/ethereum/price", getEthereumPrice) // New endpoint
+	api.GET("/campaign", getCampaign)
+	api.GET("/campaign/distributions", getCampaignDistributions)
+	api.GET("/campaign/:id/winners", getCampaignWinners)
+	api.GET("/leaderboard", getLeaderboard)
+	api.GET("/leaderboard/snapshot", getLeaderboardSnapshot)
+	api.GET("/stats/volume", getVolumeStats)
+	api.GET("/leaderboard/export", adminAuth(), exportLeaderboard)
+
+	admin := api.Group("/admin", adminAuth())
+	admin.POST("/backfill", backfillSwapEvents)
+	admin.POST("/campaign/pause", pauseCampaign)
+	admin.POST("/campaign/resume", resumeCampaign)
+	admin.GET("/swaps", getSwapsByBlockRange)
+	admin.GET("/swaps/:txHash/audit", getSwapAudit)
+	admin.POST("/loglevel", setLogLevel)
+	admin.POST("/user/:address/onboard", onboardUser)
+	admin.POST("/user/:address/reset", resetUserPoints)
+	admin.DELETE("/user/:address", deleteUser)
+	admin.GET("/onboarding-stats", getOnboardingStats)
+}
+
+// deprecated marks responses from unversioned paths so clients know to move to /v1
+func deprecated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Next()
+	}
+}
+
+// apiPrefix normalizes API_PREFIX, e.g. "api/v1/" becomes "/api/v1"; empty serves routes at the root
+func apiPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return "/"
+	}
+	return "/" + prefix
+}
+
+// configureGinMode sets gin's mode from GIN_MODE, defaulting to release mode when APP_ENV=production
+func configureGinMode() {
+	switch mode := os.Getenv("GIN_MODE"); mode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+		gin.SetMode(mode)
+	case "":
+		if os.Getenv("APP_ENV") == "production" {
+			gin.SetMode(gin.ReleaseMode)
+		}
+	default:
+		LogError("Invalid GIN_MODE %q, keeping %s mode", mode, gin.Mode())
+	}
+}
+
+// recovery catches handler panics, logs them with a stack trace and responds with a generic 500
+func recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicsRecovered.Add(1)
+				LogError("Panic handling %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, r, debug.Stack())
+				respondError(c, http.StatusInternalServerError, "Internal server error", nil)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// requestLogger logs each request through the application logger
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		LogInfo("%s %s %d %s %s request_id=%s", c.Request.Method, path, c.Writer.Status(), time.Since(start), c.ClientIP(), c.GetString(requestIDKey))
+	}
+}
+
+// extendWriteDeadline lifts the server's write timeout for a long-running response, allowing it
+// HTTP_STREAM_WRITE_TIMEOUT instead (0 removes the deadline)
+func extendWriteDeadline(c *gin.Context) {
+	var deadline time.Time
+	if timeout := envDuration("HTTP_STREAM_WRITE_TIMEOUT", defaultStreamWriteTimeout); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline); err != nil {
+		LogDebug("Could not extend the write deadline of %s: %v", c.Request.URL.Path, err)
+	}
+}
+
+// adminAuth requires the X-Admin-Key header to match the ADMIN_API_KEY environment variable.
+// Admin endpoints are disabled entirely when ADMIN_API_KEY is not set.
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := os.Getenv("ADMIN_API_KEY")
+		if apiKey == "" {
+			respondError(c, http.StatusForbidden, "Admin endpoints are disabled", nil)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Key")), []byte(apiKey)) != 1 {
+			respondError(c, http.StatusUnauthorized, "Invalid admin key", nil)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// readyTimeout bounds the database checks behind GET /ready
+const readyTimeout = 2 * time.Second
+
+// getReady reports whether the database is reachable and its tables are queryable
+func getReady(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+
+	if err := CheckDBHealth(ctx); err != nil {
+		LogError("Readiness check failed: %v", err)
+		respondError(c, http.StatusServiceUnavailable, "Database is not ready", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// getMetrics serves the swap processor gauges for Prometheus
+func getMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	writeMetrics(c.Writer)
+}
+
 func getUserTasks(c *gin.Context) {
 	address := c.Param("address")
 
-	tasks, err := GetUserTasks(address)
+	tasks, err := GetUserTasksCached(address)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user tasks"})
+		respondError(c, http.StatusInternalServerError, "Failed to fetch user tasks", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, tasks)
 }
 
+// getUserPointsHistory returns a page of a user's points history as a JSON array, or with
+// format=ndjson streams all of it as one JSON object per line
 func getUserPointsHistory(c *gin.Context) {
 	address := c.Param("address")
 
-	pointsHistory, err := GetUserPointsHistory(address)
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+	case "ndjson":
+		streamUserPointsHistory(c, address)
+		return
+	default:
+		respondError(c, http.StatusBadRequest, "format must be json or ndjson", nil)
+		return
+	}
+
+	page, err := parsePagination(c, defaultPageLimits)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user points history"})
+		respondError(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	pointsHistory, err := GetUserPointsHistory(address, page)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to fetch user points history", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, pointsHistory)
 }
 
+// streamUserPointsHistory writes a user's points history as newline-delimited JSON without
+// buffering the whole result set
+func streamUserPointsHistory(c *gin.Context, address string) {
+	extendWriteDeadline(c)
+	encoder := json.NewEncoder(c.Writer)
+
+	// As in exportLeaderboard, the response starts on the first row so a failing query can
+	// still return an error status
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+	}
+
+	rows := 0
+	err := StreamUserPointsHistory(address, func(entry map[string]interface{}) error {
+		start()
+		rows++
+		return encoder.Encode(entry)
+	})
+
+	if err != nil && !started {
+		respondError(c, http.StatusInternalServerError, "Failed to fetch user points history", err)
+		return
+	}
+	if err != nil {
+		LogError("Failed to stream points history for %s after %d rows: %v", address, rows, err)
+	}
+
+	start()
+	c.Writer.WriteHeaderNow()
+}
+
+// getUserSummary returns a user's tasks, total points, rank and recent points history in one response
+func getUserSummary(c *gin.Context) {
+	summary, err := GetUserSummary(c.Param("address"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to fetch user summary", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
 func getEthereumPrice(c *gin.Context) {
-	price, err := GetEthereumPrice()
+	quote, err := GetEthereumPriceQuote()
+	var staleErr *StalePriceError
+	if errors.As(err, &staleErr) {
+		respondError(c, http.StatusServiceUnavailable, "Ethereum price is stale", err)
+		return
+	}
+	if errors.Is(err, errPriceCircuitOpen) {
+		respondError(c, http.StatusServiceUnavailable, "Ethereum price is unavailable", err)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to fetch Ethereum price", err)
+		return
+	}
+
+	// age_seconds is how long ago Chainlink updated the price; cached is set while the
+	// circuit breaker serves the last good price
+	c.JSON(http.StatusOK, gin.H{
+		"price":       quote.Price,
+		"age_seconds": int64(time.Since(quote.UpdatedAt).Seconds()),
+		"cached":      quote.Cached,
+	})
+}
+
+func getCampaign(c *gin.Context) {
+	config, err := GetCampaignConfig()
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "No campaign configured", err)
+		return
+	}
+	var staleSince *time.Time
+	if err != nil {
+		cached, takenAt, ok := campaignSnapshots.load("current")
+		if !ok {
+			respondError(c, http.StatusInternalServerError, "Failed to fetch campaign", err)
+			return
+		}
+		LogError("Failed to fetch campaign, serving snapshot from %s: %v", takenAt.Format(time.RFC3339), err)
+		config, staleSince = cached, &takenAt
+	} else {
+		campaignSnapshots.store("current", config)
+	}
+
+	// The pool size is only known per week in volume_fraction mode
+	var weeklyPoolPoints interface{} = WeeklyPoolPoints
+	if config.WeeklyPoolMode == PoolModeVolumeFraction {
+		weeklyPoolPoints = nil
+	}
+
+	response := gin.H{
+		"start_time":           config.StartTime,
+		"end_time":             config.EndTime,
+		"is_active":            config.IsActive,
+		"is_paused":            config.Paused,
+		"start_block":          config.StartBlock,
+		"end_block":            config.EndBlock,
+		"current_week":         config.CurrentWeek(time.Now()),
+		"total_weeks":          config.TotalWeeks(),
+		"weekly_pool_mode":     config.WeeklyPoolMode,
+		"weekly_pool_points":   weeklyPoolPoints,
+		"weekly_pool_fraction": config.WeeklyPoolFraction,
+		"onboarding_threshold": OnboardingThresholdUSD,
+		"onboarding_points":    config.OnboardingPointsAt(time.Now()),
+		"max_points_per_day":   config.MaxPointsPerDay,
+	}
+	if staleSince != nil {
+		response["stale"] = true
+		response["as_of"] = *staleSince
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func getCampaignDistributions(c *gin.Context) {
+	config, err := GetCampaignConfig()
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "No campaign configured", err)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to fetch campaign", err)
+		return
+	}
+
+	distributions, err := GetWeeklyDistributions(config)
+	if err != nil {
+		LogError("Failed to fetch weekly distributions: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch weekly distributions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, distributions)
+}
+
+// Number of winners GET /campaign/:id/winners returns by default and at most
+const (
+	defaultCampaignWinners = 10
+	maxCampaignWinners     = 1000
+)
+
+// getCampaignWinners returns a campaign's final top users for reward payout, e.g. ?top=10
+func getCampaignWinners(c *gin.Context) {
+	campaignID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || campaignID < 1 {
+		respondError(c, http.StatusBadRequest, "Invalid campaign id", err)
+		return
+	}
+
+	top := defaultCampaignWinners
+	if raw := c.Query("top"); raw != "" {
+		top, err = strconv.Atoi(raw)
+		if err != nil || top < 1 || top > maxCampaignWinners {
+			respondError(c, http.StatusBadRequest, "top must be between 1 and "+strconv.Itoa(maxCampaignWinners), nil)
+			return
+		}
+	}
+
+	winners, err := GetCampaignWinners(campaignID, top)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "Campaign not found", err)
+		return
+	}
+	if err != nil {
+		LogError("Failed to fetch winners of campaign %d: %v", campaignID, err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch campaign winners", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, winners)
+}
+
+// getLeaderboard returns a page of the top users by all-time points, or by points earned in
+// the current campaign week with period=week
+func getLeaderboard(c *gin.Context) {
+	page, err := parsePagination(c, leaderboardPageLimits)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	period := c.DefaultQuery("period", "all")
+
+	var entries []LeaderboardEntry
+	switch period {
+	case "all":
+		entries, err = GetLeaderboard(page)
+	case "week":
+		// The week matches the one the weekly share pool is distributed for
+		var config CampaignConfig
+		if config, err = GetCampaignConfig(); err == nil {
+			now := time.Now()
+			entries, err = GetLeaderboardForPeriod(config.WeekStart(now), now, page)
+		}
+	default:
+		respondError(c, http.StatusBadRequest, "period must be all or week", nil)
+		return
+	}
+	key := fmt.Sprintf("%s:%d:%d", period, page.Limit, page.Offset)
+	if err != nil {
+		LogError("Failed to fetch %s leaderboard: %v", period, err)
+		if cached, takenAt, ok := leaderboardSnapshots.load(key); ok {
+			c.JSON(http.StatusOK, gin.H{"period": period, "pointsLabel": PointsLabel, "entries": cached, "stale": true, "as_of": takenAt})
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard", err)
+		return
+	}
+	leaderboardSnapshots.store(key, entries)
+
+	c.JSON(http.StatusOK, gin.H{"period": period, "pointsLabel": PointsLabel, "entries": entries})
+}
+
+// getLeaderboardSnapshot returns the current campaign's leaderboard as it was when a week was
+// distributed, e.g. ?week=2
+func getLeaderboardSnapshot(c *gin.Context) {
+	config, err := GetCampaignConfig()
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "No campaign configured", err)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to fetch campaign", err)
+		return
+	}
+
+	week, err := strconv.Atoi(c.Query("week"))
+	if err != nil || week < 1 || week > config.TotalWeeks() {
+		respondError(c, http.StatusBadRequest, "week must be between 1 and "+strconv.Itoa(config.TotalWeeks()), err)
+		return
+	}
+
+	entries, err := GetLeaderboardSnapshot(config.ID, week)
+	if err != nil {
+		LogError("Failed to fetch week %d leaderboard snapshot: %v", week, err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard snapshot", err)
+		return
+	}
+	if len(entries) == 0 {
+		respondError(c, http.StatusNotFound, "No leaderboard snapshot for week "+strconv.Itoa(week), nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"week": week, "pointsLabel": PointsLabel, "entries": entries})
+}
+
+// defaultVolumeWindow is how far back /stats/volume looks when from is not given
+const defaultVolumeWindow = 7 * 24 * time.Hour
+
+// getVolumeStats returns swap volume over time, e.g. ?interval=day&from=2024-01-01T00:00:00Z.
+// from and to are RFC 3339 times; to defaults to now and from to a week before to.
+func getVolumeStats(c *gin.Context) {
+	interval := c.DefaultQuery("interval", "day")
+	if !isVolumeInterval(interval) {
+		respondError(c, http.StatusBadRequest, "interval must be one of "+strings.Join(VolumeIntervals, ", "), nil)
+		return
+	}
+
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "to must be an RFC 3339 time", err)
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-defaultVolumeWindow)
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "from must be an RFC 3339 time", err)
+			return
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		respondError(c, http.StatusBadRequest, "from must be before to", nil)
+		return
+	}
+
+	buckets, err := GetVolumeTimeseries(interval, from, to)
+	if err != nil {
+		LogError("Failed to fetch volume timeseries: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch volume", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"interval": interval, "buckets": buckets})
+}
+
+// exportLeaderboard streams the full leaderboard as CSV (default) or a JSON array
+func exportLeaderboard(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		respondError(c, http.StatusBadRequest, "format must be csv or json", nil)
+		return
+	}
+	extendWriteDeadline(c)
+
+	csvWriter := csv.NewWriter(c.Writer)
+	jsonEncoder := json.NewEncoder(c.Writer)
+
+	// The response starts on the first row (or at the end for an empty leaderboard),
+	// so a failing query can still return an error status
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Disposition", "attachment; filename=leaderboard."+format)
+		c.Status(http.StatusOK)
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv")
+			csvWriter.Write([]string{"rank", "address", "points"})
+		} else {
+			c.Header("Content-Type", "application/json")
+			c.Writer.WriteString("[")
+		}
+	}
+
+	rows := 0
+	err := StreamLeaderboard(func(entry LeaderboardEntry) error {
+		start()
+		rows++
+		if format == "csv" {
+			return csvWriter.Write([]string{strconv.Itoa(entry.Rank), entry.Address, strconv.FormatInt(entry.Points, 10)})
+		}
+		if rows > 1 {
+			c.Writer.WriteString(",")
+		}
+		return jsonEncoder.Encode(entry)
+	})
+
+	if err != nil && !started {
+		LogError("Failed to export leaderboard: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to export leaderboard", err)
+		return
+	}
+	if err != nil {
+		// Headers are already sent, so a failure part way through can only be logged
+		LogError("Failed to export leaderboard after %d rows: %v", rows, err)
+	}
+
+	start()
+	if format == "csv" {
+		csvWriter.Flush()
+	} else {
+		c.Writer.WriteString("]")
+	}
+}
+
+// defaultBackfillMaxBlocks caps the block range of one /admin/backfill request
+const defaultBackfillMaxBlocks = 50000
+
+// backfillMaxBlocks is the largest range /admin/backfill accepts, from BACKFILL_MAX_BLOCKS; 0 disables the limit
+var backfillMaxBlocks = envUint("BACKFILL_MAX_BLOCKS", defaultBackfillMaxBlocks)
+
+func backfillSwapEvents(c *gin.Context) {
+	var req struct {
+		FromBlock *uint64 `json:"fromBlock" binding:"required"`
+		ToBlock   *uint64 `json:"toBlock" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "fromBlock and toBlock are required", err)
+		return
+	}
+	if *req.FromBlock > *req.ToBlock {
+		respondError(c, http.StatusBadRequest, "fromBlock must not be after toBlock", nil)
+		return
+	}
+	// blocks wraps to 0 for the full uint64 range
+	if blocks := *req.ToBlock - *req.FromBlock + 1; backfillMaxBlocks > 0 && (blocks > backfillMaxBlocks || blocks == 0) {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf(
+			"block range exceeds the maximum of %d blocks per backfill; split it into smaller requests", backfillMaxBlocks), nil)
+		return
+	}
+
+	extendWriteDeadline(c)
+	result, err := BackfillSwapEvents(*req.FromBlock, *req.ToBlock, DefaultBackfillChunkSize)
+	if err != nil {
+		// Progress is always returned so the caller can resume from the last completed chunk
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{
+			Code:      errorCode(err, http.StatusInternalServerError),
+			Message:   "Backfill failed",
+			RequestID: c.GetString(requestIDKey),
+			Details:   result,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func pauseCampaign(c *gin.Context) {
+	setCampaignPausedHandler(c, true)
+}
+
+func resumeCampaign(c *gin.Context) {
+	setCampaignPausedHandler(c, false)
+}
+
+func setCampaignPausedHandler(c *gin.Context, paused bool) {
+	setPaused := ResumeCampaign
+	if paused {
+		setPaused = PauseCampaign
+	}
+
+	err := setPaused()
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "No campaign configured", err)
+		return
+	}
+	if err != nil {
+		LogError("Failed to set campaign paused to %t: %v", paused, err)
+		respondError(c, http.StatusInternalServerError, "Failed to update campaign", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"paused": paused})
+}
+
+// getSwapsByBlockRange returns a page of stored swaps for reconciliation, e.g. ?fromBlock=100&toBlock=200&limit=100
+func getSwapsByBlockRange(c *gin.Context) {
+	fromBlock, err := strconv.ParseUint(c.Query("fromBlock"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "fromBlock must be a block number", err)
+		return
+	}
+	toBlock, err := strconv.ParseUint(c.Query("toBlock"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "toBlock must be a block number", err)
+		return
+	}
+	if fromBlock > toBlock {
+		respondError(c, http.StatusBadRequest, "fromBlock must not be after toBlock", nil)
+		return
+	}
+	page, err := parsePagination(c, defaultPageLimits)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	swaps, err := GetSwapsByBlockRange(fromBlock, toBlock, page)
+	if err != nil {
+		LogError("Failed to fetch swaps by block range: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch swaps", err)
+		return
+	}
+
+	// Every recorded swap is from the tracked pair. The label is informational, so failing to
+	// resolve it does not fail the listing.
+	if len(swaps) > 0 {
+		if metadata, err := GetPairMetadata(common.HexToAddress(UniswapV2PairAddress)); err != nil {
+			LogError("Failed to fetch pair metadata: %v", err)
+		} else {
+			for i := range swaps {
+				swaps[i].Pair = metadata.Label
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, swaps)
+}
+
+// deleteUser removes a user and all their swaps and points, e.g. for a removal request
+func deleteUser(c *gin.Context) {
+	address := c.Param("address")
+	if !common.IsHexAddress(address) {
+		respondError(c, http.StatusBadRequest, "Invalid address", nil)
+		return
+	}
+
+	deletion, err := DeleteUser(address)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if errors.Is(err, ErrDistributionInProgress) {
+		respondError(c, http.StatusConflict, "A weekly distribution is in progress, retry shortly", err)
+		return
+	}
+	if err != nil {
+		LogError("Failed to delete user %s: %v", address, err)
+		respondError(c, http.StatusInternalServerError, "Failed to delete user", err)
+		return
+	}
+
+	LogInfo("Deleted user %s: %d swap events, %d points history rows, %d swap audit rows redacted",
+		address, deletion.SwapEvents, deletion.PointsHistory, deletion.SwapAuditsRedacted)
+	c.JSON(http.StatusOK, gin.H{
+		"address":              checksumAddress(address),
+		"swapEventsDeleted":    deletion.SwapEvents,
+		"pointsHistoryDeleted": deletion.PointsHistory,
+		"swapAuditsRedacted":   deletion.SwapAuditsRedacted,
+	})
+}
+
+func getOnboardingStats(c *gin.Context) {
+	stats, err := GetOnboardingStats()
+	if err != nil {
+		LogError("Failed to fetch onboarding stats: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch onboarding stats", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+func getSwapAudit(c *gin.Context) {
+	audits, err := GetSwapAudit(c.Param("txHash"))
+	if err != nil {
+		LogError("Failed to fetch swap audit: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch swap audit", err)
+		return
+	}
+	if len(audits) == 0 {
+		respondError(c, http.StatusNotFound, "No audit records for transaction", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, audits)
+}
+
+// setLogLevel changes the log level at runtime, e.g. {"level": "debug"}
+func setLogLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "level is required", err)
+		return
+	}
+
+	level, err := ParseLogLevel(req.Level)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "level must be debug, info or error", err)
+		return
+	}
+
+	previous := GetLogLevel()
+	SetLogLevel(level)
+	LogInfo("Log level changed from %s to %s", previous, level)
+
+	c.JSON(http.StatusOK, gin.H{"level": level.String()})
+}
+
+// onboardUser completes onboarding for a user manually, creating the user if needed.
+// Onboarding a user who is already onboarded is a no-op.
+func onboardUser(c *gin.Context) {
+	address := c.Param("address")
+	if !common.IsHexAddress(address) {
+		respondError(c, http.StatusBadRequest, "Invalid address", nil)
+		return
+	}
+
+	userID, err := GetOrCreateUserID(address)
+	if err != nil {
+		LogError("Failed to resolve user %s: %v", address, err)
+		respondError(c, http.StatusInternalServerError, "Failed to onboard user", err)
+		return
+	}
+
+	points := OnboardingPoints
+	config, err := GetCampaignConfig()
+	switch {
+	case err == nil:
+		points = config.OnboardingPointsAt(time.Now())
+	case !errors.Is(err, sql.ErrNoRows):
+		LogError("Failed to get campaign config: %v", err)
+		respondError(c, http.StatusInternalServerError, "Failed to onboard user", err)
+		return
+	}
+
+	if err := AwardOnboardingPoints(userID, points); err != nil {
+		LogError("Failed to onboard user %s: %v", address, err)
+		respondError(c, http.StatusInternalServerError, "Failed to onboard user", err)
+		return
+	}
+
+	user, err := GetUserByAddress(address)
+	if err != nil {
+		LogError("Failed to fetch onboarded user %s: %v", address, err)
+		respondError(c, http.StatusInternalServerError, "Failed to onboard user", err)
+		return
+	}
+
+	userTasks.invalidate(address)
+	LogInfo("Onboarding completed manually for %s", user.Address)
+	user.Address = checksumAddress(user.Address)
+	c.JSON(http.StatusOK, user)
+}
+
+// resetUserPoints zeroes a flagged user's points, e.g. {"reason": "wash trading"}
+func resetUserPoints(c *gin.Context) {
+	address := c.Param("address")
+	if !common.IsHexAddress(address) {
+		respondError(c, http.StatusBadRequest, "Invalid address", nil)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required,max=200"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "reason is required and may be at most 200 characters", err)
+		return
+	}
+
+	removed, err := ZeroUserPoints(address, req.Reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "User not found", err)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Ethereum price"})
+		LogError("Failed to reset points for %s: %v", address, err)
+		respondError(c, http.StatusInternalServerError, "Failed to reset points", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"price": price})
+	LogInfo("Reset points for %s: removed %d points (%s)", address, removed, req.Reason)
+	c.JSON(http.StatusOK, gin.H{"address": checksumAddress(address), "pointsRemoved": removed})
 }
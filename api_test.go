@@ -0,0 +1,1218 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestGetCampaignHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	startTime := time.Now().Add(-8 * 24 * time.Hour)
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(startTime, startTime.Add(4*7*24*time.Hour), true, false))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/campaign", nil)
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, true, body["is_active"])
+	assert.Equal(t, 2.0, body["current_week"])
+	assert.Equal(t, 4.0, body["total_weeks"])
+	assert.Equal(t, float64(WeeklyPoolPoints), body["weekly_pool_points"])
+	assert.Equal(t, OnboardingThresholdUSD, body["onboarding_threshold"])
+	assert.Contains(t, body, "start_time")
+	assert.Contains(t, body, "end_time")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetCampaignHandlerNoCampaign(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnError(sql.ErrNoRows)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/campaign", nil)
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var body ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeNotFound, body.Code)
+	assert.Equal(t, "No campaign configured", body.Message)
+}
+
+func TestPauseCampaignHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	mock.ExpectExec("UPDATE campaign_config SET paused").
+		WithArgs(true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/campaign/pause", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/campaign/pause", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"paused": true}`, w.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetupRouterReleaseModeInProduction(t *testing.T) {
+	defer gin.SetMode(gin.TestMode)
+
+	t.Setenv("GIN_MODE", "")
+	t.Setenv("APP_ENV", "production")
+	SetupRouter()
+	assert.Equal(t, gin.ReleaseMode, gin.Mode())
+
+	t.Setenv("GIN_MODE", gin.DebugMode)
+	SetupRouter()
+	assert.Equal(t, gin.DebugMode, gin.Mode())
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/leaderboard?period=month", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/leaderboard?period=month", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+}
+
+func TestSetupRouterAPIPrefix(t *testing.T) {
+	t.Setenv("API_PREFIX", "/api/")
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/leaderboard?period=month", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/admin/loglevel", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Routes are no longer served at the root
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/leaderboard?period=month", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Metrics are served under the prefix and still at the root
+	for _, path := range []string{"/api/metrics", "/metrics"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Body.String(), "trading_ace_swap_last_processed_block")
+	}
+
+	assert.Equal(t, "/", apiPrefix(""))
+	assert.Equal(t, "/", apiPrefix("/"))
+	assert.Equal(t, "/api/v1", apiPrefix("api/v1"))
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	router := SetupRouter()
+	router.GET("/panic", func(c *gin.Context) {
+		panic("something went wrong")
+	})
+
+	before := panicsRecovered.Load()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	var body ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeInternalError, body.Code)
+	assert.Equal(t, "Internal server error", body.Message)
+	assert.NotEmpty(t, body.RequestID)
+	assert.Equal(t, body.RequestID, w.Header().Get("X-Request-ID"))
+	assert.NotContains(t, w.Body.String(), "something went wrong")
+	assert.Equal(t, before+1, panicsRecovered.Load())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "# TYPE trading_ace_http_panics_recovered_total counter\n")
+	assert.Contains(t, w.Body.String(), fmt.Sprintf("trading_ace_http_panics_recovered_total %d\n", before+1))
+}
+
+func TestExportLeaderboardCSV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 5100).
+			AddRow("0x1234567890123456789012345678901234567890", 5000).
+			AddRow("0x0987654321098765432109876543210987654321", 5000))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/leaderboard/export?format=csv", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename=leaderboard.csv", w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "rank,address,points\n"+
+		"1,0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed,5100\n"+
+		"2,0x1234567890123456789012345678901234567890,5000\n"+
+		"2,0x0987654321098765432109876543210987654321,5000\n", w.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExportLeaderboardJSON(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x1234567890123456789012345678901234567890", 100))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/leaderboard/export?format=json", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"rank": 1, "address": "0x1234567890123456789012345678901234567890", "points": 100}]`, w.Body.String())
+}
+
+func TestGetUserPointsHistoryNDJSON(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery("SELECT points, reason, timestamp FROM points_history").
+		WithArgs("0x1234567890123456789012345678901234567890").
+		WillReturnRows(sqlmock.NewRows([]string{"points", "reason", "timestamp"}).
+			AddRow(200, "Weekly share pool distribution", "2024-01-08T00:00:00Z").
+			AddRow(100, "Onboarding task completed", "2024-01-01T00:00:00Z"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/user/0x1234567890123456789012345678901234567890/points?format=ndjson", nil)
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if assert.Len(t, lines, 2) {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+		assert.Equal(t, float64(200), entry["points"])
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+		assert.Equal(t, "Onboarding task completed", entry["reason"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStreamingEndpointsOutliveWriteTimeout(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	tests := []struct {
+		name  string
+		path  string
+		query string
+		rows  *sqlmock.Rows
+		body  string
+	}{
+		{
+			name:  "leaderboard export",
+			path:  "/leaderboard/export",
+			query: "SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history",
+			rows:  sqlmock.NewRows([]string{"address", "points"}).AddRow("0x1234567890123456789012345678901234567890", 100),
+			body:  "rank,address,points\n1,0x1234567890123456789012345678901234567890,100\n",
+		},
+		{
+			name:  "points history ndjson",
+			path:  "/v1/user/0x1234567890123456789012345678901234567890/points?format=ndjson",
+			query: "SELECT points, reason, timestamp FROM points_history",
+			rows:  sqlmock.NewRows([]string{"points", "reason", "timestamp"}).AddRow(100, "Onboarding task completed", "2024-01-01T00:00:00Z"),
+			body:  `{"points":100,"reason":"Onboarding task completed","timestamp":"2024-01-01T00:00:00Z"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			SetDB(db)
+
+			// The query outlasts the server's write timeout, which would cut the response off
+			mock.ExpectQuery(tt.query).WillDelayFor(300 * time.Millisecond).WillReturnRows(tt.rows)
+
+			server := httptest.NewUnstartedServer(SetupRouter())
+			server.Config.WriteTimeout = 100 * time.Millisecond
+			server.Start()
+			defer server.Close()
+
+			req, _ := http.NewRequest("GET", server.URL+tt.path, nil)
+			req.Header.Set("X-Admin-Key", "secret")
+			resp, err := server.Client().Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.body, string(body))
+		})
+	}
+}
+
+func TestGetUserPointsHistoryRejectsUnknownFormat(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/user/0x1234567890123456789012345678901234567890/points?format=xml", nil)
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetUserPointsHistoryPagination(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// A missing limit uses the default page size
+	mock.ExpectQuery("SELECT points, reason, timestamp FROM points_history .* LIMIT \\$2 OFFSET \\$3").
+		WithArgs("0x1234567890123456789012345678901234567890", 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"points", "reason", "timestamp"}).
+			AddRow(100, "Onboarding task completed", "2024-01-01T00:00:00Z"))
+	mock.ExpectQuery("SELECT points, reason, timestamp FROM points_history").
+		WithArgs("0x1234567890123456789012345678901234567890", 5, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"points", "reason", "timestamp"}))
+
+	router := SetupRouter()
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"?limit=5&offset=10", http.StatusOK},
+		{"?limit=101", http.StatusBadRequest},
+		{"?limit=0", http.StatusBadRequest},
+		{"?offset=-1", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/user/0x1234567890123456789012345678901234567890/points"+tc.query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.query)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestErrorResponseNotFound(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/does-not-exist", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"code": "NOT_FOUND", "message": "Route not found", "request_id": "req-123"}`, w.Body.String())
+}
+
+func TestErrorResponseHidesDetailsOutsideDebug(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// Without a snapshot to fall back on the error is returned
+	originalCampaigns := campaignSnapshots
+	defer func() { campaignSnapshots = originalCampaigns }()
+	campaignSnapshots = newSnapshotCache[CampaignConfig](time.Minute)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnError(errors.New("pq: connection to 10.0.0.5 refused"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/campaign", nil)
+	req.Header.Set("X-Request-ID", "req-456")
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"code": "INTERNAL_ERROR", "message": "Failed to fetch campaign", "request_id": "req-456"}`, w.Body.String())
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, CodeEthereumError, errorCode(&EthereumError{Operation: "FilterLogs", Err: errors.New("timeout")}, http.StatusInternalServerError))
+	assert.Equal(t, CodeNotFound, errorCode(fmt.Errorf("campaign: %w", sql.ErrNoRows), http.StatusInternalServerError))
+	assert.Equal(t, CodeBadRequest, errorCode(nil, http.StatusBadRequest))
+	assert.Equal(t, CodeInternalError, errorCode(errors.New("boom"), http.StatusInternalServerError))
+}
+
+func TestGetSwapAuditHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	txHash := "0xabcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890"
+	processedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM audit_swap_processing WHERE LOWER\\(transaction_hash\\) = LOWER\\(\\$1\\)").
+		WithArgs(txHash).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_hash", "block_number", "log_index", "sender", "reserve0", "reserve1",
+			"price_source", "usd_value", "points", "rule_version", "processed_at"}).
+			AddRow(txHash, 12345, 0, "0x1234567890123456789012345678901234567890", nil, nil,
+				"chainlink", 2000.0, 100, "v1", processedAt))
+	mock.ExpectQuery("SELECT (.+) FROM audit_swap_processing").
+		WithArgs("0x01").
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_hash"}))
+
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/swaps/"+txHash+"/audit", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"txHash": "`+txHash+`", "blockNumber": 12345, "logIndex": 0,
+		"sender": "0x1234567890123456789012345678901234567890", "priceSource": "chainlink",
+		"usdValue": 2000, "points": 100, "ruleVersion": "v1", "processedAt": "2024-01-02T03:04:05Z"}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/swaps/0x01/audit", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetSwapsByBlockRangeHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	// Swaps are labelled with the tracked pair's token symbols
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	tokenMetadata = newTokenCache()
+	pair := common.HexToAddress(UniswapV2PairAddress)
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	mockPairDecimals(mockClient, pair, weth, usdc, 18, 6)
+	mockTokenSymbols(mockClient, map[common.Address]string{weth: "WETH", usdc: "USDC"})
+
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM swap_events s JOIN users u ON u.id = s.user_id WHERE s.block_number BETWEEN \\$1 AND \\$2").
+		WithArgs(uint64(100), uint64(200), 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_hash", "address", "amount_usd", "block_number", "log_index", "timestamp"}).
+			AddRow("0xabc", "0xabcdef0123456789abcdef0123456789abcdef01", 1500.5, 150, 4, timestamp).
+			AddRow("0xdef", "0xabcdef0123456789abcdef0123456789abcdef01", 10.0, 160, nil, timestamp))
+
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/swaps?fromBlock=100&toBlock=200", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"txHash": "0xabc", "address": "0xabCDeF0123456789AbcdEf0123456789aBCDEF01",
+		"amountUSD": 1500.5, "blockNumber": 150, "logIndex": 4, "timestamp": "2024-01-02T03:04:05Z", "pair": "WETH/USDC"},
+		{"txHash": "0xdef", "address": "0xabCDeF0123456789AbcdEf0123456789aBCDEF01",
+		"amountUSD": 10, "blockNumber": 160, "logIndex": null, "timestamp": "2024-01-02T03:04:05Z", "pair": "WETH/USDC"}]`, w.Body.String())
+
+	// Later pages are requested with limit and offset
+	mock.ExpectQuery("SELECT (.+) FROM swap_events s").
+		WithArgs(uint64(100), uint64(200), 50, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_hash", "address", "amount_usd", "block_number", "log_index", "timestamp"}))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/swaps?fromBlock=100&toBlock=200&limit=50&offset=100", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	for _, query := range []string{"toBlock=200", "fromBlock=abc&toBlock=200", "fromBlock=300&toBlock=200", "fromBlock=100&toBlock=200&limit=101"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/admin/swaps?"+query, nil)
+		req.Header.Set("X-Admin-Key", "secret")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBackfillHandlerRejectsLargeRange(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	originalMax := backfillMaxBlocks
+	defer func() { backfillMaxBlocks = originalMax }()
+	backfillMaxBlocks = 1000
+
+	router := SetupRouter()
+	backfill := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/admin/backfill", strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"fromBlock": 17000000, "toBlock": 17001000}`,
+		`{"fromBlock": 0, "toBlock": 18446744073709551615}`,
+	} {
+		w := backfill(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+
+		var response ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "block range exceeds the maximum of 1000 blocks per backfill; split it into smaller requests", response.Message)
+	}
+
+	// Exactly the maximum is accepted
+	mockClient.On("FilterLogs", mock.Anything, mock.Anything).Return([]types.Log{}, nil).Once()
+	w := backfill(`{"fromBlock": 17000000, "toBlock": 17000999}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestGetOnboardingStatsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FILTER \\(WHERE onboarding_completed\\), COUNT\\(\\*\\) FILTER \\(WHERE NOT onboarding_completed\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"onboarded", "not_onboarded"}).AddRow(12, 30))
+	mock.ExpectQuery("SELECT DISTINCT ON \\(se.user_id\\) se.amount_usd AS amount FROM swap_events se (.+) WHERE u.onboarding_completed AND se.amount_usd >= \\$1").
+		WithArgs(OnboardingThresholdUSD).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min", "median", "p90", "max", "avg"}).
+			AddRow(11, 1000.0, 1500.0, 9000.0, 25000.5, 3200.25))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FILTER").
+		WillReturnError(errors.New("connection refused"))
+
+	router := SetupRouter()
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/admin/onboarding-stats", nil)
+		req.Header.Set("X-Admin-Key", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"onboarded": 12, "notOnboarded": 30, "amounts": {"count": 11, "min": 1000, "median": 1500,
+		"p90": 9000, "max": 25000.5, "average": 3200.25}}`, w.Body.String())
+
+	assert.Equal(t, http.StatusInternalServerError, get().Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUserSummaryHandlerUnknownUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, onboarding_completed, onboarding_points, COALESCE").
+		WithArgs("0x1234567890123456789012345678901234567890").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/user/0x1234567890123456789012345678901234567890/summary", nil)
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetLeaderboardHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	originalLabel := PointsLabel
+	defer func() { PointsLabel = originalLabel }()
+	PointsLabel = pointsLabel(" ACE ")
+
+	// The weekly leaderboard starts at the current campaign week, not 7 days ago
+	start := time.Now().Add(-10 * 24 * time.Hour).UTC().Truncate(time.Second)
+	mock.ExpectQuery(campaignConfigQuery).WillReturnRows(campaignConfigRows(start, start.Add(4*CampaignWeek), true, false))
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history ph JOIN users u ON u.id = ph.user_id WHERE ph.timestamp").
+		WithArgs(start.Add(CampaignWeek), sqlmock.AnyArg(), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x1234567890123456789012345678901234567890", 100))
+
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/leaderboard?period=week&limit=5", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"period": "week", "pointsLabel": "ACE", "entries": [{"rank": 1, "address": "0x1234567890123456789012345678901234567890", "points": 100}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/leaderboard?period=month", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetCampaignWinnersHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(4 * CampaignWeek)
+
+	// Points are scoped to the campaign's time window, including the final distribution at its end
+	mock.ExpectQuery("SELECT start_time, end_time, is_active FROM campaign_config WHERE id = \\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"start_time", "end_time", "is_active"}).AddRow(start, end, false))
+	mock.ExpectQuery("FROM points_history ph JOIN users u ON u.id = ph.user_id WHERE ph.timestamp >= \\$1 AND ph.timestamp <= \\$2 (.+) LIMIT \\$3").
+		WithArgs(start, end, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 12000).
+			AddRow("0x1234567890123456789012345678901234567890", 8100))
+	mock.ExpectQuery("SELECT start_time, end_time, is_active FROM campaign_config").WithArgs(9).
+		WillReturnError(sql.ErrNoRows)
+
+	router := SetupRouter()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/v1/campaign/2/winners?top=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"campaignId": 2, "startTime": "2024-01-01T00:00:00Z", "endTime": "2024-01-29T00:00:00Z", "final": true, "winners": [
+		{"rank": 1, "address": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "points": 12000},
+		{"rank": 2, "address": "0x1234567890123456789012345678901234567890", "points": 8100}
+	]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/v1/campaign/9/winners").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/campaign/2/winners?top=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/campaign/latest/winners").Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	limits := defaultPageLimits
+	parse := func(query string) (Pagination, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+query, nil)
+		return parsePagination(c, limits)
+	}
+
+	page, err := parse("")
+	assert.NoError(t, err)
+	assert.Equal(t, Pagination{Limit: 20}, page)
+
+	page, err = parse("limit=100&offset=40")
+	assert.NoError(t, err)
+	assert.Equal(t, Pagination{Limit: 100, Offset: 40}, page)
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=ten", "limit=-1"} {
+		_, err = parse(query)
+		assert.EqualError(t, err, "limit must be between 1 and 100", query)
+	}
+	for _, query := range []string{"offset=-1", "offset=1.5"} {
+		_, err = parse(query)
+		assert.EqualError(t, err, "offset must be a non-negative integer", query)
+	}
+}
+
+func TestPageLimitsFromEnv(t *testing.T) {
+	def := PageLimits{Default: 20, Max: 100}
+	assert.Equal(t, def, pageLimitsFromEnv("TEST", def))
+
+	t.Setenv("TEST_PAGE_SIZE", "50")
+	t.Setenv("TEST_MAX_PAGE_SIZE", "500")
+	assert.Equal(t, PageLimits{Default: 50, Max: 500}, pageLimitsFromEnv("TEST", def))
+
+	// A default above the max is rejected as a whole
+	t.Setenv("TEST_MAX_PAGE_SIZE", "10")
+	assert.Equal(t, def, pageLimitsFromEnv("TEST", def))
+}
+
+func TestGetLeaderboardSnapshotHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	start := time.Now().Add(-3 * CampaignWeek)
+	campaign := func() { // campaign 1 runs for 4 weeks
+		mock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(start, start.Add(4*CampaignWeek), true, false))
+	}
+
+	campaign()
+	mock.ExpectQuery("SELECT rank, address, points FROM leaderboard_snapshots").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"rank", "address", "points"}).
+			AddRow(1, "0x1234567890123456789012345678901234567890", 5100).
+			AddRow(1, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 5100))
+	campaign()
+	mock.ExpectQuery("SELECT rank, address, points FROM leaderboard_snapshots").
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"rank", "address", "points"}))
+	campaign()
+
+	router := SetupRouter()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/v1/leaderboard/snapshot?week=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"week": 2, "pointsLabel": "points", "entries": [
+		{"rank": 1, "address": "0x1234567890123456789012345678901234567890", "points": 5100},
+		{"rank": 1, "address": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "points": 5100}
+	]}`, w.Body.String())
+
+	// Week 3 has not been distributed yet
+	assert.Equal(t, http.StatusNotFound, get("/v1/leaderboard/snapshot?week=3").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/leaderboard/snapshot?week=5").Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetLeaderboardHandlerServesSnapshotOnDBError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	originalLeaderboards, originalCampaigns := leaderboardSnapshots, campaignSnapshots
+	defer func() { leaderboardSnapshots, campaignSnapshots = originalLeaderboards, originalCampaigns }()
+	now := time.Now()
+	leaderboardSnapshots = newSnapshotCache[[]LeaderboardEntry](time.Minute)
+	leaderboardSnapshots.now = func() time.Time { return now }
+	campaignSnapshots = newSnapshotCache[CampaignConfig](time.Minute)
+
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x1234567890123456789012345678901234567890", 100))
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history").
+		WillReturnError(errors.New("connection refused"))
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history").
+		WillReturnError(errors.New("connection refused"))
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(now.Add(-time.Hour), now.Add(4*CampaignWeek), true, false))
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnError(errors.New("connection refused"))
+
+	router := SetupRouter()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	entries := `[{"rank": 1, "address": "0x1234567890123456789012345678901234567890", "points": 100}]`
+	w := get("/v1/leaderboard?limit=10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"period": "all", "pointsLabel": "points", "entries": `+entries+`}`, w.Body.String())
+
+	// The query fails, so the last good leaderboard is served and marked stale
+	w = get("/v1/leaderboard?limit=10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"period": "all", "pointsLabel": "points", "entries": `+entries+`, "stale": true, "as_of": "`+now.Format(time.RFC3339Nano)+`"}`, w.Body.String())
+
+	// Past the max age the snapshot is no longer served
+	now = now.Add(2 * time.Minute)
+	w = get("/v1/leaderboard?limit=10")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	assert.Equal(t, http.StatusOK, get("/v1/campaign").Code)
+	w = get("/v1/campaign")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, true, body["stale"])
+	assert.Equal(t, true, body["is_active"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetVolumeStatsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT date_trunc\\(\\$1, timestamp\\) AS bucket, SUM\\(amount_usd\\) FROM swap_events WHERE timestamp >= \\$2 AND timestamp < \\$3 GROUP BY bucket").
+		WithArgs("day", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "sum"}).
+			AddRow(from, 1500.25).
+			AddRow(from.Add(24*time.Hour), 320.0))
+
+	router := SetupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/stats/volume?interval=day&from=2024-01-01T00:00:00Z&to=2024-01-03T00:00:00Z", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"interval": "day", "buckets": [
+		{"timestamp": "2024-01-01T00:00:00Z", "volumeUSD": 1500.25},
+		{"timestamp": "2024-01-02T00:00:00Z", "volumeUSD": 320}]}`, w.Body.String())
+
+	for _, query := range []string{"interval=minute", "from=yesterday", "from=2024-01-03T00:00:00Z&to=2024-01-01T00:00:00Z"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v1/stats/volume?"+query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetReadyHandlerMissingTable(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT 1 FROM users LIMIT 1").WillReturnError(errors.New(`pq: relation "users" does not exist`))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ready", nil)
+	req.Header.Set("X-Request-ID", "req-789")
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"code": "SERVICE_UNAVAILABLE", "message": "Database is not ready", "request_id": "req-789"}`, w.Body.String())
+}
+
+func TestSetLogLevelHandler(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	var buf bytes.Buffer
+	originalOutput := debugLogger.Writer()
+	debugLogger.SetOutput(&buf)
+	defer func() {
+		debugLogger.SetOutput(originalOutput)
+		SetLogLevel(LevelInfo)
+	}()
+
+	router := SetupRouter()
+	setLevel := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/loglevel", strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "secret")
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	SetLogLevel(LevelInfo)
+	LogDebug("hidden at info")
+	assert.Empty(t, buf.String())
+
+	w := setLevel(`{"level": "DEBUG"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"level": "debug"}`, w.Body.String())
+	LogDebug("shown at debug")
+	assert.Contains(t, buf.String(), "shown at debug")
+
+	buf.Reset()
+	w = setLevel(`{"level": "info"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	LogDebug("hidden again")
+	assert.Empty(t, buf.String())
+
+	w = setLevel(`{"level": "verbose"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, LevelInfo, GetLogLevel())
+}
+
+func TestResetUserPointsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower := strings.ToLower(address)
+
+	// A leaderboard snapshot taken before the reset still lists the user's points
+	originalLeaderboards := leaderboardSnapshots
+	defer func() { leaderboardSnapshots = originalLeaderboards }()
+	leaderboardSnapshots = newSnapshotCache[[]LeaderboardEntry](time.Minute)
+	leaderboardSnapshots.store("all:20:0", []LeaderboardEntry{{Rank: 1, Address: address, Points: 2600}})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE address = \\$1 FOR UPDATE").WithArgs(lower).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(points\\), 0\\) FROM points_history WHERE user_id = \\$1").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(2600))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(7, int64(-2600), "Admin adjustment: wash trading", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Unknown users are not created
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE address = \\$1 FOR UPDATE").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	router := SetupRouter()
+	reset := func(address, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/user/"+address+"/reset", strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := reset(address, `{"reason": "wash trading"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"address": "`+address+`", "pointsRemoved": 2600}`, w.Body.String())
+	_, _, ok := leaderboardSnapshots.load("all:20:0")
+	assert.False(t, ok, "leaderboard snapshots are cleared by a reset")
+
+	w = reset("0x0000000000000000000000000000000000000001", `{"reason": "wash trading"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = reset(address, `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = reset("not-an-address", `{"reason": "wash trading"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower := strings.ToLower(address)
+	lockRows := func(locked bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(locked)
+	}
+
+	// The user's points history, swaps, leaderboard snapshot entries and the user row itself are all
+	// removed, and their address is redacted from the swap audit rows
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock\\(\\$1\\)").WithArgs(distributionLockKey).
+		WillReturnRows(lockRows(true))
+	mock.ExpectQuery("SELECT id FROM users WHERE address = \\$1 FOR UPDATE").WithArgs(lower).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("DELETE FROM points_history WHERE user_id = \\$1").WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM swap_events WHERE user_id = \\$1").WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec("UPDATE audit_swap_processing SET sender = \\$1 WHERE sender = \\$2").WithArgs("redacted", lower).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec("DELETE FROM leaderboard_snapshots WHERE address = \\$1").WithArgs(lower).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM users WHERE id = \\$1").WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Unknown users
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock").WillReturnRows(lockRows(true))
+	mock.ExpectQuery("SELECT id FROM users WHERE address = \\$1 FOR UPDATE").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	// A running distribution holds the lock, so nothing is deleted
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock").WillReturnRows(lockRows(false))
+	mock.ExpectRollback()
+
+	router := SetupRouter()
+	remove := func(address string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/admin/user/"+address, nil)
+		req.Header.Set("X-Admin-Key", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := remove(lower)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"address": "`+address+`", "swapEventsDeleted": 5, "pointsHistoryDeleted": 3, "swapAuditsRedacted": 5}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, remove("0x0000000000000000000000000000000000000001").Code)
+
+	w = remove(address)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var body ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeConflict, body.Code)
+
+	assert.Equal(t, http.StatusBadRequest, remove("not-an-address").Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestOnboardUserHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower := strings.ToLower(address)
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "address", "onboarding_completed", "onboarding_points", "total_points"}).
+			AddRow(7, lower, true, 100, 100)
+	}
+
+	// First call onboards the user
+	mock.ExpectQuery("INSERT INTO users").WithArgs(lower).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(campaignConfigQuery).WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET onboarding_completed = true").WithArgs(7, OnboardingPoints).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO points_history").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT u.id, u.address, u.onboarding_completed").
+		WillReturnRows(userRows())
+
+	// Second call finds the user already onboarded and awards nothing
+	mock.ExpectQuery("INSERT INTO users").WithArgs(lower).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(campaignConfigQuery).WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET onboarding_completed = true").WithArgs(7, OnboardingPoints).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT u.id, u.address, u.onboarding_completed").
+		WillReturnRows(userRows())
+
+	router := SetupRouter()
+	expected := `{"id": 7, "address": "` + address + `", "onboardingCompleted": true, "onboardingPoints": 100, "totalPoints": 100}`
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/user/"+lower+"/onboard", nil)
+		req.Header.Set("X-Admin-Key", "secret")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, expected, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/user/not-an-address/onboard", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
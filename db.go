@@ -1,32 +1,230 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-var DB *sql.DB
+// currentDB holds the shared connection pool. It is only accessed through DB and SetDB so that
+// tests can swap it while background goroutines are reading it.
+var currentDB atomic.Pointer[sql.DB]
+
+// DB returns the current database handle
+func DB() *sql.DB {
+	return currentDB.Load()
+}
+
+// SetDB replaces the database handle, e.g. with a sqlmock connection in tests
+func SetDB(db *sql.DB) {
+	currentDB.Store(db)
+}
+
+const (
+	// OnboardingThresholdUSD is the minimum swap value that completes the onboarding task
+	OnboardingThresholdUSD = 1000.0
+	// OnboardingPoints is awarded once for completing the onboarding task
+	OnboardingPoints = 100
+	// WeeklyPoolPoints is distributed each week across users by share of swap volume
+	WeeklyPoolPoints = 10000
+	// CampaignWeeks is the default campaign length
+	CampaignWeeks = 4
+	// CampaignWeek is the length of one campaign week
+	CampaignWeek = 7 * 24 * time.Hour
+	// SwapRuleVersion identifies the swap valuation and points rules recorded in the swap audit log.
+	// Bump it whenever those rules change.
+	SwapRuleVersion = "v1"
+	// ReasonOnboarding is the points_history reason for the onboarding task award
+	ReasonOnboarding = "Onboarding task completed"
+	// ReasonWeeklySharePool is the points_history reason for weekly share pool awards
+	ReasonWeeklySharePool = "Weekly Share Pool Task"
+	// ReasonReorgReversal is the points_history reason for points taken back because their swap was reorged out
+	ReasonReorgReversal = "Reorg reversal"
+	// ReasonAdminAdjustment prefixes the points_history reason of a manual change such as a points reset
+	ReasonAdminAdjustment = "Admin adjustment"
+	// reasonLegacyPointsReset prefixes points resets recorded before ReasonAdminAdjustment
+	reasonLegacyPointsReset = "Points reset"
+)
+
+// earnedPointsFilter excludes reorg reversals and admin adjustments from points_history. They
+// still count towards a user's total, but are not points earned by completing tasks.
+const earnedPointsFilter = `reason <> '` + ReasonReorgReversal + `' AND reason NOT LIKE '` + ReasonAdminAdjustment + `%' AND reason NOT LIKE '` + reasonLegacyPointsReset + `%'`
 
 type CampaignConfig struct {
 	ID        int
 	StartTime time.Time
 	EndTime   time.Time
 	IsActive  bool
+	// Paused temporarily stops point accrual without ending the campaign
+	Paused bool
+	// StartBlock and EndBlock bound the campaign on-chain; zero means unbounded
+	StartBlock uint64
+	EndBlock   uint64
+	// WeeklyPoolMode is PoolModeFixed or PoolModeVolumeFraction
+	WeeklyPoolMode string
+	// WeeklyPoolFraction is the share of weekly USD volume paid out as points in PoolModeVolumeFraction
+	WeeklyPoolFraction float64
+	// OnboardingSchedule lists the onboarding points for weeks 1, 2, ...; empty awards OnboardingPoints
+	OnboardingSchedule []int64
+	// MaxPointsPerDay caps the points a user can earn from swaps per UTC day; 0 disables the cap
+	MaxPointsPerDay int
+}
+
+// Weekly pool modes
+const (
+	// PoolModeFixed distributes WeeklyPoolPoints every week
+	PoolModeFixed = "fixed"
+	// PoolModeVolumeFraction distributes WeeklyPoolFraction * the week's total USD volume
+	PoolModeVolumeFraction = "volume_fraction"
+)
+
+// WeeklyPool returns the points to distribute for a week with totalVolumeUSD of swaps
+func (c CampaignConfig) WeeklyPool(totalVolumeUSD float64) int {
+	if c.WeeklyPoolMode == PoolModeVolumeFraction {
+		return int(math.Floor(c.WeeklyPoolFraction * totalVolumeUSD))
+	}
+	return WeeklyPoolPoints
+}
+
+// OnboardingPointsAt returns the points for completing onboarding at t. Weeks past the end of
+// the schedule, and times outside the campaign, award OnboardingPoints.
+func (c CampaignConfig) OnboardingPointsAt(t time.Time) int {
+	week := c.CurrentWeek(t)
+	if week < 1 || week > len(c.OnboardingSchedule) || t.After(c.EndTime) {
+		return OnboardingPoints
+	}
+	return int(c.OnboardingSchedule[week-1])
+}
+
+// CompletedWeek returns the most recent campaign week that ended at or before t and its
+// [start, end) bounds. ok is false until the first week has ended.
+func (c CampaignConfig) CompletedWeek(t time.Time) (week int, start, end time.Time, ok bool) {
+	if t.Before(c.StartTime) {
+		return 0, time.Time{}, time.Time{}, false
+	}
+
+	if t.Before(c.EndTime) {
+		week = int(t.Sub(c.StartTime) / CampaignWeek)
+	} else {
+		// Every week has ended, including a trailing partial week
+		week = c.TotalWeeks()
+	}
+	if week < 1 {
+		return 0, time.Time{}, time.Time{}, false
+	}
+
+	start, end = c.WeekBounds(week)
+	return week, start, end, true
+}
+
+// WeekBounds returns the [start, end) bounds of the 1-based campaign week. The last week ends
+// with the campaign, so it may be shorter.
+func (c CampaignConfig) WeekBounds(week int) (start, end time.Time) {
+	start = c.StartTime.Add(time.Duration(week-1) * CampaignWeek)
+	end = start.Add(CampaignWeek)
+	if end.After(c.EndTime) {
+		end = c.EndTime
+	}
+	return start, end
+}
+
+// ContainsBlock reports whether blockNumber falls within the campaign's block range
+func (c CampaignConfig) ContainsBlock(blockNumber uint64) bool {
+	if c.StartBlock != 0 && blockNumber < c.StartBlock {
+		return false
+	}
+	if c.EndBlock != 0 && blockNumber > c.EndBlock {
+		return false
+	}
+	return true
+}
+
+// TotalWeeks returns the number of campaign weeks, counting a trailing partial week
+func (c CampaignConfig) TotalWeeks() int {
+	duration := c.EndTime.Sub(c.StartTime)
+	if duration <= 0 {
+		return 0
+	}
+	return int((duration + CampaignWeek - 1) / CampaignWeek)
+}
+
+// CurrentWeek returns the 1-based campaign week containing t,
+// 0 before the campaign starts and TotalWeeks after it ends
+func (c CampaignConfig) CurrentWeek(t time.Time) int {
+	if t.Before(c.StartTime) {
+		return 0
+	}
+	week := int(t.Sub(c.StartTime)/CampaignWeek) + 1
+	if total := c.TotalWeeks(); week > total {
+		return total
+	}
+	return week
+}
+
+// WeekStart returns the start of the campaign week containing t
+func (c CampaignConfig) WeekStart(t time.Time) time.Time {
+	week := c.CurrentWeek(t)
+	if week < 1 {
+		return c.StartTime
+	}
+	return c.StartTime.Add(time.Duration(week-1) * CampaignWeek)
+}
+
+// healthCheckTables must exist and be readable for the database to be considered ready
+var healthCheckTables = []string{"users", "swap_events", "points_history", "campaign_config"}
+
+// DBHealthError reports which database readiness check failed
+type DBHealthError struct {
+	Check string
+	Err   error
+}
+
+func (e *DBHealthError) Error() string {
+	return fmt.Sprintf("database health check %s failed: %v", e.Check, e.Err)
+}
+
+func (e *DBHealthError) Unwrap() error {
+	return e.Err
+}
+
+// CheckDBHealth pings the database and runs a trivial query against each key table, so a
+// missing table or failed migration is caught even when the connection itself is fine
+func CheckDBHealth(ctx context.Context) error {
+	if err := DB().PingContext(ctx); err != nil {
+		return &DBHealthError{Check: "ping", Err: err}
+	}
+
+	for _, table := range healthCheckTables {
+		var one int
+		err := DB().QueryRowContext(ctx, "SELECT 1 FROM "+table+" LIMIT 1").Scan(&one)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return &DBHealthError{Check: table, Err: err}
+		}
+	}
+	return nil
 }
 
 func InitDB() error {
 	connStr := "host=localhost port=5432 user=user password=password dbname=tradingace sslmode=disable"
-	var err error
-	DB, err = sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
+	SetDB(db)
 
-	err = DB.Ping()
+	err = DB().Ping()
 	if err != nil {
 		return fmt.Errorf("failed to ping database: %v", err)
 	}
@@ -34,7 +232,7 @@ func InitDB() error {
 	log.Println("Successfully connected to database")
 
 	// Run migrations
-	err = runMigrations(DB)
+	err = runMigrations(DB())
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %v", err)
 	}
@@ -43,13 +241,25 @@ func InitDB() error {
 }
 
 func GetUserTasks(address string) (map[string]interface{}, error) {
+	return queryUserTasks(DB(), address)
+}
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// queryUserTasks reads the user and their tasks through q, so a caller's transaction can share its snapshot
+func queryUserTasks(q rowQuerier, address string) (map[string]interface{}, error) {
+	address = normalizeAddress(address)
+
 	var user struct {
 		ID                  int
 		OnboardingCompleted bool
 		OnboardingPoints    int
 		OnboardingAmount    float64
 	}
-	err := DB.QueryRow(`
+	err := q.QueryRow(`
         SELECT id, onboarding_completed, onboarding_points, 
                COALESCE((SELECT amount_usd FROM swap_events WHERE user_id = users.id ORDER BY timestamp ASC LIMIT 1), 0) as onboarding_amount
         FROM users 
@@ -58,14 +268,25 @@ func GetUserTasks(address string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	partial := false
+
 	var sharePoolAmount, sharePoolPoints float64
-	err = DB.QueryRow(`
+	var totalPoints, earnedPoints int64
+	sharePoolAvailable := true
+	err = q.QueryRow(`
         SELECT COALESCE(SUM(amount_usd), 0),
-               COALESCE((SELECT SUM(points) FROM points_history WHERE user_id = $1 AND reason = 'Weekly Share Pool Task'), 0)
+               COALESCE((SELECT SUM(points) FROM points_history WHERE user_id = $1 AND reason = '`+ReasonWeeklySharePool+`'), 0),
+               COALESCE((SELECT SUM(points) FROM points_history WHERE user_id = $1), 0),
+               COALESCE((SELECT SUM(points) FROM points_history WHERE user_id = $1 AND `+earnedPointsFilter+`), 0)
         FROM swap_events 
-        WHERE user_id = $1`, user.ID).Scan(&sharePoolAmount, &sharePoolPoints)
+        WHERE user_id = $1`, user.ID).Scan(&sharePoolAmount, &sharePoolPoints, &totalPoints, &earnedPoints)
 	if err != nil {
-		return nil, err
+		if userTasksStrict {
+			return nil, err
+		}
+		LogError("Share pool data unavailable for %s: %v", address, err)
+		sharePoolAvailable = false
+		partial = true
 	}
 
 	// Get the latest campaign config
@@ -74,19 +295,33 @@ func GetUserTasks(address string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	// Check if the user is eligible for the current share pool distribution
-	var latestDistribution time.Time
-	err = DB.QueryRow(`
-        SELECT COALESCE(MAX(timestamp), $1)
+	// A user is eligible until they receive a distribution during the current campaign week.
+	// Distributions are stamped with the end of the week they pay out, which is the start
+	// of the week they are made in.
+	var latestDistribution sql.NullTime
+	var isEligibleForCurrentDistribution interface{}
+	err = q.QueryRow(`
+        SELECT MAX(timestamp)
         FROM points_history
-        WHERE user_id = $2 AND reason = 'Weekly Share Pool Task'`, campaignConfig.StartTime, user.ID).Scan(&latestDistribution)
+        WHERE user_id = $1 AND reason = '`+ReasonWeeklySharePool+`'`, user.ID).Scan(&latestDistribution)
 	if err != nil {
-		return nil, err
+		if userTasksStrict {
+			return nil, err
+		}
+		// Eligibility is reported as null rather than guessed
+		LogError("Share pool eligibility unavailable for %s: %v", address, err)
+		partial = true
+	} else {
+		isEligibleForCurrentDistribution = !latestDistribution.Valid ||
+			latestDistribution.Time.Before(campaignConfig.WeekStart(time.Now()))
 	}
 
-	isEligibleForCurrentDistribution := latestDistribution.Before(time.Now().AddDate(0, 0, -7))
-
 	tasks := map[string]interface{}{
+		"address":     checksumAddress(address),
+		"totalPoints": totalPoints,
+		// Points from tasks, before reorg reversals and admin adjustments
+		"earnedPoints": earnedPoints,
+		"pointsLabel":  PointsLabel,
 		"onboarding": map[string]interface{}{
 			"completed": user.OnboardingCompleted,
 			"amount":    user.OnboardingAmount,
@@ -102,256 +337,1540 @@ func GetUserTasks(address string) (map[string]interface{}, error) {
 			"startTime": campaignConfig.StartTime,
 			"endTime":   campaignConfig.EndTime,
 			"isActive":  campaignConfig.IsActive,
+			"isPaused":  campaignConfig.Paused,
 		},
 	}
+	if !sharePoolAvailable {
+		tasks["totalPoints"] = nil
+		tasks["earnedPoints"] = nil
+		tasks["sharePool"] = map[string]interface{}{
+			"unavailable": true,
+			"eligible":    isEligibleForCurrentDistribution,
+		}
+	}
+	if partial {
+		tasks["partial"] = true
+	}
 
 	return tasks, nil
 }
 
-func GetUserPointsHistory(address string) ([]map[string]interface{}, error) {
-	rows, err := DB.Query("SELECT points, reason, timestamp FROM points_history WHERE user_id = (SELECT id FROM users WHERE address = $1) ORDER BY timestamp DESC", address)
+// PointsLabel is the name points are displayed under, e.g. "ACE", from POINTS_LABEL.
+// It is returned alongside points in /leaderboard and /user/:address/tasks.
+var PointsLabel = pointsLabel(os.Getenv("POINTS_LABEL"))
+
+// pointsLabel returns label, or "points" when it is blank
+func pointsLabel(label string) string {
+	if label = strings.TrimSpace(label); label == "" {
+		return "points"
+	}
+	return label
+}
+
+// userTasksStrict fails GetUserTasks when any query fails, from USER_TASKS_STRICT=true.
+// By default a failed share pool or distribution query only marks that data unavailable.
+var userTasksStrict = os.Getenv("USER_TASKS_STRICT") == "true"
+
+// userTasksCache holds recent GetUserTasks results so frequent UI polling does not rerun its queries
+type userTasksCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]userTasksEntry
+}
+
+type userTasksEntry struct {
+	tasks     map[string]interface{}
+	expiresAt time.Time
+}
+
+func newUserTasksCache(ttl time.Duration) *userTasksCache {
+	return &userTasksCache{ttl: ttl, now: time.Now, entries: make(map[string]userTasksEntry)}
+}
+
+// userTasks caches GetUserTasks for USER_TASKS_CACHE_TTL (default 5s); a zero TTL disables caching
+var userTasks = newUserTasksCache(envDuration("USER_TASKS_CACHE_TTL", 5*time.Second))
+
+// GetUserTasksCached returns GetUserTasks for address, served from the cache while fresh.
+// The returned map is shared with other callers and must not be modified.
+func GetUserTasksCached(address string) (map[string]interface{}, error) {
+	return userTasks.get(normalizeAddress(address), GetUserTasks)
+}
+
+func (c *userTasksCache) get(address string, load func(string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if c.ttl <= 0 {
+		return load(address)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[address]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expiresAt) {
+		return entry.tasks, nil
+	}
+
+	tasks, err := load(address)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	if tasks["partial"] == true {
+		return tasks, nil // Retry the failed queries on the next request
+	}
+
+	c.mu.Lock()
+	c.entries[address] = userTasksEntry{tasks: tasks, expiresAt: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return tasks, nil
+}
+
+// invalidate drops the cached tasks for address
+func (c *userTasksCache) invalidate(address string) {
+	c.mu.Lock()
+	delete(c.entries, normalizeAddress(address))
+	c.mu.Unlock()
+}
+
+// invalidateAll drops every cached entry, e.g. after a weekly distribution
+func (c *userTasksCache) invalidateAll() {
+	c.mu.Lock()
+	c.entries = make(map[string]userTasksEntry)
+	c.mu.Unlock()
+}
+
+// defaultSnapshotMaxAge is how long a last-known snapshot may be served while the database fails
+const defaultSnapshotMaxAge = 5 * time.Minute
+
+// snapshotCache keeps the last successful result per key so it can be served, marked stale,
+// when the database is briefly unavailable
+type snapshotCache[T any] struct {
+	mu      sync.Mutex
+	maxAge  time.Duration
+	now     func() time.Time
+	entries map[string]snapshot[T]
+}
+
+type snapshot[T any] struct {
+	value   T
+	takenAt time.Time
+}
+
+func newSnapshotCache[T any](maxAge time.Duration) *snapshotCache[T] {
+	return &snapshotCache[T]{maxAge: maxAge, now: time.Now, entries: make(map[string]snapshot[T])}
+}
+
+// store records value as the latest snapshot for key
+func (c *snapshotCache[T]) store(key string, value T) {
+	c.mu.Lock()
+	c.entries[key] = snapshot[T]{value: value, takenAt: c.now()}
+	c.mu.Unlock()
+}
+
+// load returns the snapshot for key and when it was taken; ok is false when there is none
+// or it is older than maxAge. A zero maxAge disables serving snapshots.
+func (c *snapshotCache[T]) load(key string) (value T, takenAt time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[key]
+	if !found || c.maxAge <= 0 || c.now().Sub(entry.takenAt) > c.maxAge {
+		return value, time.Time{}, false
+	}
+	return entry.value, entry.takenAt, true
+}
+
+// clear drops every snapshot, e.g. when one may contain deleted data
+func (c *snapshotCache[T]) clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]snapshot[T])
+	c.mu.Unlock()
+}
 
+// Last-known leaderboards (keyed by period and limit) and campaign config, served for up to
+// SNAPSHOT_MAX_AGE (default 5m) when their queries fail
+var (
+	snapshotMaxAge       = envDuration("SNAPSHOT_MAX_AGE", defaultSnapshotMaxAge)
+	leaderboardSnapshots = newSnapshotCache[[]LeaderboardEntry](snapshotMaxAge)
+	campaignSnapshots    = newSnapshotCache[CampaignConfig](snapshotMaxAge)
+)
+
+// GetUserPointsHistory returns a page of a user's points history, newest first
+func GetUserPointsHistory(address string, page Pagination) ([]map[string]interface{}, error) {
 	var pointsHistory []map[string]interface{}
+	err := queryUserPointsHistory(address, &page, func(entry map[string]interface{}) error {
+		pointsHistory = append(pointsHistory, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pointsHistory, nil
+}
+
+// StreamUserPointsHistory calls fn for each of a user's points history rows, newest first,
+// as they are read from the cursor. An error from fn stops the iteration and is returned.
+func StreamUserPointsHistory(address string, fn func(map[string]interface{}) error) error {
+	return queryUserPointsHistory(address, nil, fn)
+}
+
+// queryUserPointsHistory streams a user's points history, limited to page unless it is nil
+func queryUserPointsHistory(address string, page *Pagination, fn func(map[string]interface{}) error) error {
+	query := "SELECT points, reason, timestamp FROM points_history WHERE user_id = (SELECT id FROM users WHERE address = $1) ORDER BY timestamp DESC"
+	args := []interface{}{normalizeAddress(address)}
+	if page != nil {
+		query += " LIMIT $2 OFFSET $3"
+		args = append(args, page.Limit, page.Offset)
+	}
+
+	rows, err := DB().Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var points int
 		var reason string
 		var timestamp string
 		err := rows.Scan(&points, &reason, &timestamp)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		pointsHistory = append(pointsHistory, map[string]interface{}{
+		err = fn(map[string]interface{}{
 			"timestamp": timestamp,
 			"points":    points,
 			"reason":    reason,
 		})
+		if err != nil {
+			return err
+		}
 	}
 
-	return pointsHistory, nil
+	return rows.Err()
 }
 
-func RecordSwap(address string, amountUSD float64, txHash string) error {
-	config, err := GetCampaignConfig()
+// summaryHistoryLimit is how many recent points history entries GetUserSummary returns
+const summaryHistoryLimit = 10
+
+// UserSummary is a user's tasks, total points, leaderboard rank and most recent points history.
+// Rank is nil for users without positive points, who are not on the leaderboard.
+type UserSummary struct {
+	Tasks        map[string]interface{}   `json:"tasks"`
+	TotalPoints  int64                    `json:"totalPoints"`
+	Rank         *int                     `json:"rank"`
+	RecentPoints []map[string]interface{} `json:"recentPoints"`
+}
+
+// GetUserSummary combines the tasks, rank and points history lookups for one user. Tasks, total
+// points, rank and recent history are read in a single read-only transaction so they agree with
+// each other. It returns sql.ErrNoRows for unknown users.
+func GetUserSummary(address string) (UserSummary, error) {
+	tx, err := DB().BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
 	if err != nil {
-		return LogErrorf(err, "failed to get campaign config")
+		return UserSummary{}, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	now := time.Now()
-	if !config.IsActive || now.Before(config.StartTime) || now.After(config.EndTime) {
-		return nil // Silently ignore swaps outside the campaign timeframe
+	tasks, err := queryUserTasks(tx, address)
+	if err != nil {
+		return UserSummary{}, err
 	}
+	summary := UserSummary{Tasks: tasks, RecentPoints: []map[string]interface{}{}}
 
+	// Users with equal points share a rank, as on the leaderboard
 	var userID int
-	err = DB.QueryRow("INSERT INTO users (address) VALUES ($1) ON CONFLICT (address) DO UPDATE SET address = EXCLUDED.address RETURNING id", address).Scan(&userID)
+	var rank sql.NullInt64
+	err = tx.QueryRow(`
+        WITH totals AS (
+            SELECT user_id, SUM(points) AS points FROM points_history GROUP BY user_id
+        )
+        SELECT u.id, COALESCE(t.points, 0),
+               CASE WHEN t.points > 0 THEN (SELECT COUNT(*) + 1 FROM totals WHERE totals.points > t.points) END
+        FROM users u
+        LEFT JOIN totals t ON t.user_id = u.id
+        WHERE u.address = $1`, normalizeAddress(address)).Scan(&userID, &summary.TotalPoints, &rank)
 	if err != nil {
-		return LogErrorf(err, "failed to insert or get user")
+		return UserSummary{}, err
 	}
-
-	tx, err := DB.Begin()
-	if err != nil {
-		return LogErrorf(err, "failed to begin transaction")
+	if rank.Valid {
+		r := int(rank.Int64)
+		summary.Rank = &r
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec("INSERT INTO swap_events (user_id, transaction_hash, amount_usd, timestamp) VALUES ($1, $2, $3, $4)",
-		userID, txHash, amountUSD, now)
+	rows, err := tx.Query("SELECT points, reason, timestamp FROM points_history WHERE user_id = $1 ORDER BY timestamp DESC LIMIT $2", userID, summaryHistoryLimit)
 	if err != nil {
-		return LogErrorf(err, "failed to insert swap event")
+		return UserSummary{}, fmt.Errorf("failed to query points history: %w", err)
 	}
+	defer rows.Close()
 
-	if amountUSD >= 1000 {
-		var onboardingCompleted bool
-		err = tx.QueryRow("SELECT onboarding_completed FROM users WHERE id = $1", userID).Scan(&onboardingCompleted)
-		if err != nil {
-			return LogErrorf(err, "failed to check onboarding status")
-		}
-
-		if !onboardingCompleted {
-			_, err = tx.Exec("UPDATE users SET onboarding_completed = true, onboarding_points = 100 WHERE id = $1", userID)
-			if err != nil {
-				return LogErrorf(err, "failed to update onboarding status")
-			}
-
-			_, err = tx.Exec("INSERT INTO points_history (user_id, points, reason, timestamp) VALUES ($1, 100, 'Onboarding task completed', $2)",
-				userID, now)
-			if err != nil {
-				return LogErrorf(err, "failed to insert onboarding points history")
-			}
+	for rows.Next() {
+		var points int
+		var reason string
+		var timestamp string
+		if err := rows.Scan(&points, &reason, &timestamp); err != nil {
+			return UserSummary{}, fmt.Errorf("failed to scan points history: %w", err)
 		}
+		summary.RecentPoints = append(summary.RecentPoints, map[string]interface{}{
+			"timestamp": timestamp,
+			"points":    points,
+			"reason":    reason,
+		})
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		return LogErrorf(err, "failed to commit transaction")
+	if err := rows.Err(); err != nil {
+		return UserSummary{}, fmt.Errorf("error iterating over points history rows: %w", err)
 	}
 
-	return nil
+	return summary, nil
 }
 
-func CalculateWeeklySharePoolPoints() error {
-	config, err := GetCampaignConfig()
-	if err != nil {
-		return fmt.Errorf("failed to get campaign config: %v", err)
-	}
+// User is a user's onboarding status and total points
+type User struct {
+	ID                  int    `json:"id"`
+	Address             string `json:"address"`
+	OnboardingCompleted bool   `json:"onboardingCompleted"`
+	OnboardingPoints    int    `json:"onboardingPoints"`
+	TotalPoints         int64  `json:"totalPoints"`
+}
 
-	now := time.Now()
-	if !config.IsActive || now.Before(config.StartTime) || now.After(config.EndTime) {
-		log.Println("Campaign is not active or has ended, skipping point distribution")
-		return nil
+// GetOrCreateUserID returns the ID of the user with address, creating the user if needed
+func GetOrCreateUserID(address string) (int, error) {
+	var userID int
+	err := DB().QueryRow("INSERT INTO users (address) VALUES ($1) ON CONFLICT (address) DO UPDATE SET address = EXCLUDED.address RETURNING id", normalizeAddress(address)).Scan(&userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert or get user: %w", err)
 	}
+	return userID, nil
+}
 
-	// Check if this is the last week of the campaign
-	isLastWeek := now.Add(7 * 24 * time.Hour).After(config.EndTime)
-
-	tx, err := DB.Begin()
+// GetUserByAddress looks up a single user, returning a wrapped sql.ErrNoRows if there is none
+func GetUserByAddress(address string) (User, error) {
+	users, err := GetUsersByAddresses([]string{address})
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return User{}, err
 	}
-	defer tx.Rollback()
+	user, ok := users[normalizeAddress(address)]
+	if !ok {
+		return User{}, fmt.Errorf("no user with address %s: %w", address, sql.ErrNoRows)
+	}
+	return user, nil
+}
 
-	// Get the total swap volume for the week
-	var totalVolume float64
-	err = tx.QueryRow(`
-        SELECT COALESCE(SUM(amount_usd), 0)
-        FROM swap_events
-        WHERE timestamp >= $1 AND timestamp < $2
-    `, now.Add(-7*24*time.Hour), now).Scan(&totalVolume)
-	if err != nil {
-		return fmt.Errorf("failed to get total volume: %v", err)
+// GetUsersByAddresses looks up several users in one query. The result is keyed by
+// lowercased address; addresses without a user are omitted.
+func GetUsersByAddresses(addresses []string) (map[string]User, error) {
+	users := make(map[string]User, len(addresses))
+	if len(addresses) == 0 {
+		return users, nil
 	}
 
-	if totalVolume == 0 {
-		log.Println("No swaps this week, skipping point distribution")
-		return nil
+	normalized := make([]string, len(addresses))
+	for i, address := range addresses {
+		normalized[i] = normalizeAddress(address)
 	}
 
-	// Fetch all eligible users and their volumes
-	rows, err := tx.Query(`
-        SELECT u.id, u.address, COALESCE(SUM(se.amount_usd), 0) as volume
+	rows, err := DB().Query(`
+        SELECT u.id, u.address, u.onboarding_completed, u.onboarding_points,
+               COALESCE((SELECT SUM(points) FROM points_history WHERE user_id = u.id), 0)
         FROM users u
-        LEFT JOIN swap_events se ON u.id = se.user_id AND se.timestamp >= $1 AND se.timestamp < $2
-        WHERE u.onboarding_completed = true
-        GROUP BY u.id, u.address
-        HAVING COALESCE(SUM(se.amount_usd), 0) > 0
-        ORDER BY volume DESC
-    `, now.Add(-7*24*time.Hour), now)
+        WHERE u.address = ANY($1)`, pq.Array(normalized))
 	if err != nil {
-		return fmt.Errorf("failed to query user volumes: %v", err)
+		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
 	defer rows.Close()
 
-	type UserData struct {
-		ID      int
-		Address string
-		Volume  float64
-	}
-
-	var users []UserData
 	for rows.Next() {
-		var user UserData
-		if err := rows.Scan(&user.ID, &user.Address, &user.Volume); err != nil {
-			return fmt.Errorf("failed to scan user data: %v", err)
+		var user User
+		if err := rows.Scan(&user.ID, &user.Address, &user.OnboardingCompleted, &user.OnboardingPoints, &user.TotalPoints); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
-		users = append(users, user)
+		users[user.Address] = user
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating over user rows: %v", err)
+		return nil, fmt.Errorf("error iterating over user rows: %w", err)
 	}
+	return users, nil
+}
 
-	totalPoints := 10000
-	remainingPoints := totalPoints
+// LeaderboardEntry is a user's standing by total points
+type LeaderboardEntry struct {
+	Rank    int    `json:"rank"`
+	Address string `json:"address"`
+	Points  int64  `json:"points"`
+}
 
-	// Distribute points
-	for i, user := range users {
-		var points int
-		if i == len(users)-1 {
-			// Last user gets all remaining points
-			points = remainingPoints
-		} else {
-			points = int((user.Volume / totalVolume) * float64(totalPoints))
-			if points == 0 {
-				points = 1 // Ensure users with very small volume get at least 1 point
-			}
-		}
-		remainingPoints -= points
+// Leaderboard tie-break rules for users with equal points, set with LEADERBOARD_TIE_BREAK.
+// Either way the order is deterministic, so ranks don't jitter between queries.
+const (
+	// TieBreakAddress orders tied users by address
+	TieBreakAddress = "address"
+	// TieBreakEarliest orders tied users by who reached their points first
+	TieBreakEarliest = "earliest"
+)
 
-		_, err = tx.Exec(`
-            INSERT INTO points_history (user_id, points, reason, timestamp)
-            VALUES ($1, $2, $3, $4)
-        `, user.ID, points, "Weekly Share Pool Task", now)
-		if err != nil {
-			return fmt.Errorf("failed to insert points history for user %s: %v", user.Address, err)
-		}
+var leaderboardTieBreak = parseTieBreak(os.Getenv("LEADERBOARD_TIE_BREAK"))
 
-		log.Printf("Awarded %d points to user %s for Weekly Share Pool Task", points, user.Address)
+func parseTieBreak(rule string) string {
+	switch rule = strings.ToLower(strings.TrimSpace(rule)); rule {
+	case "":
+		return TieBreakAddress
+	case TieBreakAddress, TieBreakEarliest:
+		return rule
+	default:
+		LogError("Invalid LEADERBOARD_TIE_BREAK %q, ordering tied users by address", rule)
+		return TieBreakAddress
 	}
+}
 
-	if isLastWeek {
-		_, err = tx.Exec("UPDATE campaign_config SET is_active = false WHERE id = $1", config.ID)
-		if err != nil {
-			return fmt.Errorf("failed to deactivate campaign: %v", err)
+// leaderboardOrderBy returns the ORDER BY clause for rule. Address is always the final key.
+func leaderboardOrderBy(rule string) string {
+	if rule == TieBreakEarliest {
+		return "ORDER BY points DESC, MAX(ph.timestamp) ASC, u.address ASC"
+	}
+	return "ORDER BY points DESC, u.address ASC"
+}
+
+// StreamLeaderboard calls fn for each user's total points in rank order, reading rows
+// as they arrive rather than loading the whole leaderboard into memory.
+// Users with equal points share a rank.
+func StreamLeaderboard(fn func(LeaderboardEntry) error) error {
+	rows, err := DB().Query(`
+        SELECT u.address, SUM(ph.points) AS points
+        FROM points_history ph
+        JOIN users u ON u.id = ph.user_id
+        GROUP BY u.address
+        HAVING SUM(ph.points) > 0
+        ` + leaderboardOrderBy(leaderboardTieBreak))
+	if err != nil {
+		return fmt.Errorf("failed to query leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	return scanLeaderboard(rows, fn)
+}
+
+// errLeaderboardLimit stops StreamLeaderboard once enough entries have been read
+var errLeaderboardLimit = errors.New("leaderboard limit reached")
+
+// GetLeaderboard returns a page of users by all-time points. Ranks are over the whole
+// leaderboard, so the first entry of a later page keeps its overall rank.
+func GetLeaderboard(page Pagination) ([]LeaderboardEntry, error) {
+	entries := []LeaderboardEntry{}
+	skipped := 0
+	err := StreamLeaderboard(func(entry LeaderboardEntry) error {
+		if skipped < page.Offset {
+			skipped++
+			return nil
 		}
-		log.Println("Campaign has ended. Deactivated in the database.")
+		if len(entries) == page.Limit {
+			return errLeaderboardLimit
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLeaderboardLimit) {
+		return nil, err
 	}
+	return entries, nil
+}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+// GetLeaderboardForPeriod returns a page of users by points earned in [start, end), ranked
+// over the whole period like GetLeaderboard
+func GetLeaderboardForPeriod(start, end time.Time, page Pagination) ([]LeaderboardEntry, error) {
+	rows, err := DB().Query(`
+        SELECT u.address, SUM(ph.points) AS points
+        FROM points_history ph
+        JOIN users u ON u.id = ph.user_id
+        WHERE ph.timestamp >= $1 AND ph.timestamp < $2
+        GROUP BY u.address
+        HAVING SUM(ph.points) > 0
+        `+leaderboardOrderBy(leaderboardTieBreak)+`
+        LIMIT $3`, start, end, page.Offset+page.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query leaderboard for period: %w", err)
 	}
+	defer rows.Close()
 
-	log.Printf("Weekly share pool points calculated and distributed. Total points: %d, Users rewarded: %d", totalPoints, len(users))
-	return nil
+	// Skipped rows are still scanned so ties across the page boundary share a rank
+	entries := []LeaderboardEntry{}
+	skipped := 0
+	err = scanLeaderboard(rows, func(entry LeaderboardEntry) error {
+		if skipped < page.Offset {
+			skipped++
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
 }
-func GetCampaignConfig() (CampaignConfig, error) {
-	var config CampaignConfig
-	err := DB.QueryRow("SELECT id, start_time, end_time, is_active FROM campaign_config ORDER BY id DESC LIMIT 1").
-		Scan(&config.ID, &config.StartTime, &config.EndTime, &config.IsActive)
+
+// CountUsers returns the number of users, for pagination totals
+func CountUsers() (int64, error) {
+	var count int64
+	if err := DB().QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// CountLeaderboard returns the number of users on the all-time leaderboard, those with
+// positive points, for pagination totals
+func CountLeaderboard() (int64, error) {
+	var count int64
+	err := DB().QueryRow(`
+        SELECT COUNT(*) FROM (
+            SELECT user_id FROM points_history GROUP BY user_id HAVING SUM(points) > 0
+        ) AS ranked`).Scan(&count)
 	if err != nil {
-		return CampaignConfig{}, fmt.Errorf("failed to get campaign config: %v", err)
+		return 0, fmt.Errorf("failed to count leaderboard: %w", err)
 	}
-	return config, nil
+	return count, nil
 }
 
-func SetCampaignConfig(startTime time.Time) error {
-	endTime := startTime.Add(4 * 7 * 24 * time.Hour) // 4 weeks
-	_, err := DB.Exec("INSERT INTO campaign_config (start_time, end_time, is_active) VALUES ($1, $2, $3)",
-		startTime, endTime, true)
+// CountUserSwaps returns the number of swaps recorded for address, 0 for unknown users
+func CountUserSwaps(address string) (int64, error) {
+	var count int64
+	err := DB().QueryRow("SELECT COUNT(*) FROM swap_events WHERE user_id = (SELECT id FROM users WHERE address = $1)",
+		normalizeAddress(address)).Scan(&count)
 	if err != nil {
-		return fmt.Errorf("failed to set campaign config: %v", err)
+		return 0, fmt.Errorf("failed to count swaps for %s: %w", address, err)
 	}
-	return nil
+	return count, nil
+}
+
+// CampaignWinners are a campaign's top users by points earned during it
+type CampaignWinners struct {
+	CampaignID int       `json:"campaignId"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	// Final is false while the campaign is active and its standings can still change
+	Final   bool               `json:"final"`
+	Winners []LeaderboardEntry `json:"winners"`
 }
 
-func AwardOnboardingPoints(userID int) error {
-	tx, err := DB.Begin()
+// GetCampaignWinners returns the top topN users by points dated within the campaign, including
+// its final distribution, which is stamped with the end time. It returns a wrapped
+// sql.ErrNoRows if there is no such campaign.
+func GetCampaignWinners(campaignID, topN int) (CampaignWinners, error) {
+	winners := CampaignWinners{CampaignID: campaignID, Winners: []LeaderboardEntry{}}
+
+	var isActive bool
+	err := DB().QueryRow("SELECT start_time, end_time, is_active FROM campaign_config WHERE id = $1", campaignID).
+		Scan(&winners.StartTime, &winners.EndTime, &isActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CampaignWinners{}, fmt.Errorf("no campaign with id %d: %w", campaignID, err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return CampaignWinners{}, fmt.Errorf("failed to get campaign %d: %w", campaignID, err)
 	}
-	defer tx.Rollback()
+	winners.Final = !isActive
+
+	rows, err := DB().Query(`
+        SELECT u.address, SUM(ph.points) AS points
+        FROM points_history ph
+        JOIN users u ON u.id = ph.user_id
+        WHERE ph.timestamp >= $1 AND ph.timestamp <= $2
+        GROUP BY u.address
+        HAVING SUM(ph.points) > 0
+        `+leaderboardOrderBy(leaderboardTieBreak)+`
+        LIMIT $3`, winners.StartTime, winners.EndTime, topN)
+	if err != nil {
+		return CampaignWinners{}, fmt.Errorf("failed to query campaign winners: %w", err)
+	}
+	defer rows.Close()
 
-	_, err = tx.Exec(`
-        UPDATE users SET onboarding_completed = true, onboarding_points = 100
-        WHERE id = $1 AND onboarding_completed = false
-    `, userID)
+	err = scanLeaderboard(rows, func(entry LeaderboardEntry) error {
+		winners.Winners = append(winners.Winners, entry)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to award onboarding points: %v", err)
+		return CampaignWinners{}, err
 	}
+	return winners, nil
+}
 
-	_, err = tx.Exec(`
-        INSERT INTO points_history (user_id, points, reason, timestamp)
-        VALUES ($1, $2, $3, $4)
-    `, userID, 100, "Onboarding task completed", time.Now())
+// GetLeaderboardSnapshot returns the leaderboard recorded when week of the campaign was
+// distributed, or an empty slice if that week has no snapshot
+func GetLeaderboardSnapshot(campaignID, week int) ([]LeaderboardEntry, error) {
+	rows, err := DB().Query(`
+        SELECT rank, address, points
+        FROM leaderboard_snapshots
+        WHERE campaign_id = $1 AND week = $2
+        ORDER BY rank ASC, address ASC`, campaignID, week)
 	if err != nil {
-		return fmt.Errorf("failed to record onboarding points: %v", err)
+		return nil, fmt.Errorf("failed to query leaderboard snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var entry LeaderboardEntry
+		if err := rows.Scan(&entry.Rank, &entry.Address, &entry.Points); err != nil {
+			return nil, fmt.Errorf("failed to scan leaderboard snapshot entry: %w", err)
+		}
+		entry.Address = checksumAddress(entry.Address)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over leaderboard snapshot rows: %w", err)
 	}
+	return entries, nil
+}
+
+// scanLeaderboard ranks (address, points) rows already ordered by points descending
+func scanLeaderboard(rows *sql.Rows, fn func(LeaderboardEntry) error) error {
+	var previous LeaderboardEntry
+	for position := 1; rows.Next(); position++ {
+		var entry LeaderboardEntry
+		if err := rows.Scan(&entry.Address, &entry.Points); err != nil {
+			return fmt.Errorf("failed to scan leaderboard entry: %w", err)
+		}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		entry.Rank = position
+		if position > 1 && entry.Points == previous.Points {
+			entry.Rank = previous.Rank
+		}
+		previous = entry
+
+		entry.Address = checksumAddress(entry.Address)
+		if err := fn(entry); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over leaderboard rows: %w", err)
+	}
+	return nil
+}
+
+// txMaxAttempts bounds how many times withTx runs a transaction aborted by a serialization failure or deadlock
+const txMaxAttempts = 3
+
+// withTx runs fn in a transaction, committing when fn returns nil and rolling back otherwise.
+// Transactions aborted by a serialization failure or deadlock are retried, so fn must reset
+// any state it sets outside the transaction.
+func withTx(fn func(*sql.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= txMaxAttempts; attempt++ {
+		err = runTx(fn)
+		if err == nil || !isRetryableTxError(err) {
+			return err
+		}
+		LogError("Transaction attempt %d of %d failed, retrying: %v", attempt, txMaxAttempts, err)
+	}
+	return err
+}
+
+func runTx(fn func(*sql.Tx) error) error {
+	tx, err := DB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// isRetryableTxError reports whether err is a Postgres serialization failure or deadlock
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
+
+// RecordSwap stores a swap and completes the user's onboarding task if it qualifies.
+// timestamp is the swap's block time; it is stored with the swap and decides the campaign
+// window, onboarding points week and daily cap day. It returns the points awarded for the swap
+// and whether it was newly stored, which is false for swaps outside the campaign and swaps that
+// were already recorded.
+func RecordSwap(address string, amountUSD float64, txHash string, blockNumber uint64, logIndex uint, timestamp time.Time) (int, bool, error) {
+	config, err := GetCampaignConfig()
+	if err != nil {
+		return 0, false, LogErrorf(err, "failed to get campaign config")
+	}
+
+	if !config.IsActive || timestamp.Before(config.StartTime) || timestamp.After(config.EndTime) {
+		return 0, false, nil // Silently ignore swaps outside the campaign timeframe
+	}
+
+	userID, err := GetOrCreateUserID(address)
+	if err != nil {
+		return 0, false, LogErrorf(err, "failed to insert or get user")
+	}
+
+	points := 0
+	recorded := false
+	err = withTx(func(tx *sql.Tx) error {
+		points = 0
+		recorded = false
+
+		// Swaps recorded before log indexes were stored have a NULL log_index and were unique per
+		// transaction, so a transaction with such a row is already recorded
+		result, err := tx.Exec(`
+            INSERT INTO swap_events (user_id, transaction_hash, amount_usd, timestamp, block_number, log_index)
+            SELECT $1, $2, $3, $4, $5, $6
+            WHERE NOT EXISTS (SELECT 1 FROM swap_events WHERE transaction_hash = $2 AND log_index IS NULL)
+            ON CONFLICT (transaction_hash, log_index) DO NOTHING`,
+			userID, txHash, amountUSD, timestamp, blockNumber, logIndex)
+		if err != nil {
+			return LogErrorf(err, "failed to insert swap event")
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return LogErrorf(err, "failed to check inserted swap event")
+		}
+		if inserted == 0 {
+			return nil // Swap already recorded, e.g. when re-processing a block range
+		}
+		recorded = true
+
+		if config.Paused {
+			LogInfo("Campaign is paused, recorded swap %s without awarding points", txHash)
+			return nil
+		}
+		if amountUSD < OnboardingThresholdUSD {
+			return nil
+		}
+
+		// The guarded update only succeeds for the first qualifying swap, so concurrent
+		// swaps from the same new user cannot both award onboarding points
+		onboardingPoints := config.OnboardingPointsAt(timestamp)
+		if config.MaxPointsPerDay > 0 {
+			onboardingPoints, err = clampToDailyCap(tx, userID, onboardingPoints, config.MaxPointsPerDay, timestamp)
+			if err != nil {
+				return err
+			}
+			if onboardingPoints == 0 {
+				return nil // Onboarding stays open, so a qualifying swap on a later day can complete it
+			}
+		}
+		result, err = tx.Exec("UPDATE users SET onboarding_completed = true, onboarding_points = $2 WHERE id = $1 AND onboarding_completed = false", userID, onboardingPoints)
+		if err != nil {
+			return LogErrorf(err, "failed to update onboarding status")
+		}
+
+		onboarded, err := result.RowsAffected()
+		if err != nil {
+			return LogErrorf(err, "failed to check onboarding status update")
+		}
+
+		if onboarded > 0 {
+			_, err = tx.Exec("INSERT INTO points_history (user_id, points, reason, timestamp) VALUES ($1, $2, '"+ReasonOnboarding+"', $3) ON CONFLICT (user_id) WHERE reason = '"+ReasonOnboarding+"' DO NOTHING",
+				userID, onboardingPoints, timestamp)
+			if err != nil {
+				return LogErrorf(err, "failed to insert onboarding points history")
+			}
+			points = onboardingPoints
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	userTasks.invalidate(address)
+	return points, recorded, nil
+}
+
+// clampToDailyCap reduces points so the user's points earned since midnight UTC stay within
+// maxPerDay. Reduced awards are logged and counted on /metrics.
+func clampToDailyCap(tx *sql.Tx, userID, points, maxPerDay int, now time.Time) (int, error) {
+	dayStart := time.Date(now.UTC().Year(), now.UTC().Month(), now.UTC().Day(), 0, 0, 0, 0, time.UTC)
+
+	var earnedToday int
+	err := tx.QueryRow("SELECT COALESCE(SUM(points), 0) FROM points_history WHERE user_id = $1 AND timestamp >= $2 AND "+earnedPointsFilter,
+		userID, dayStart).Scan(&earnedToday)
+	if err != nil {
+		return 0, LogErrorf(err, "failed to sum today's points")
+	}
+
+	allowed := max(maxPerDay-earnedToday, 0)
+	if points <= allowed {
+		return points, nil
+	}
+	LogInfo("User %d reached the daily cap of %d points (%d earned today), awarding %d of %d points",
+		userID, maxPerDay, earnedToday, allowed, points)
+	pointsClampedTotal.Add(1)
+	return allowed, nil
+}
+
+// weeklyPoolMaxDiscrepancy is how many points a weekly distribution may differ from its pool
+// before it is rolled back, from WEEKLY_POOL_MAX_DISCREPANCY; -1 only logs discrepancies
+var weeklyPoolMaxDiscrepancy = parseMaxDiscrepancy(os.Getenv("WEEKLY_POOL_MAX_DISCREPANCY"))
+
+func parseMaxDiscrepancy(raw string) int {
+	if raw == "" {
+		return -1
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		LogError("Invalid WEEKLY_POOL_MAX_DISCREPANCY %q, only logging discrepancies", raw)
+		return -1
+	}
+	return n
+}
+
+// verifyDistribution checks that the points awarded for week add up to the pool. Any
+// discrepancy is logged; one larger than maxDiscrepancy is an error so the distribution
+// is rolled back, unless maxDiscrepancy is negative.
+func verifyDistribution(week, awarded, pool, maxDiscrepancy int) error {
+	discrepancy := awarded - pool
+	if discrepancy == 0 {
+		return nil
+	}
+
+	LogError("Week %d share pool awarded %d points but the pool is %d (discrepancy %d)", week, awarded, pool, discrepancy)
+	if maxDiscrepancy >= 0 && (discrepancy > maxDiscrepancy || -discrepancy > maxDiscrepancy) {
+		return fmt.Errorf("week %d share pool awarded %d of %d points, more than %d off", week, awarded, pool, maxDiscrepancy)
+	}
+	return nil
+}
+
+// CalculateWeeklySharePoolPoints distributes the share pool for the most recently completed
+// campaign week. Weeks are aligned to the campaign start, so a run that happens late still
+// pays out the week that ended, and each week is only paid out once.
+func CalculateWeeklySharePoolPoints() error {
+	return calculateWeeklySharePoolPoints(time.Now())
+}
+
+func calculateWeeklySharePoolPoints(now time.Time) error {
+	config, err := GetCampaignConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get campaign config: %v", err)
+	}
+
+	week, weekStart, weekEnd, ok := config.CompletedWeek(now)
+	if !ok {
+		log.Println("No campaign week has completed yet, skipping point distribution")
+		return nil
+	}
+	return distributeSharePoolWeek(config, week, weekStart, weekEnd)
+}
+
+// catchUpWeeklySharePools distributes every completed campaign week that has not been paid out
+// yet, including weeks that ended while the service was down. Weeks are paid in order and the
+// first failure stops the run.
+func catchUpWeeklySharePools(now time.Time) error {
+	config, err := GetCampaignConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get campaign config: %w", err)
+	}
+
+	completed, _, _, ok := config.CompletedWeek(now)
+	if !ok {
+		return nil
+	}
+	for week := 1; week <= completed; week++ {
+		weekStart, weekEnd := config.WeekBounds(week)
+		if err := distributeSharePoolWeek(config, week, weekStart, weekEnd); err != nil {
+			return fmt.Errorf("failed to distribute week %d share pool: %w", week, err)
+		}
+	}
+	return nil
+}
+
+// distributeSharePoolWeek pays out the share pool for one completed campaign week, unless it
+// has already been paid
+func distributeSharePoolWeek(config CampaignConfig, week int, weekStart, weekEnd time.Time) error {
+	if config.Paused {
+		log.Println("Campaign is paused, skipping point distribution")
+		return nil
+	}
+
+	isLastWeek := week == config.TotalWeeks()
+
+	totalPoints := 0
+	rewarded := 0
+	err := withTx(func(tx *sql.Tx) error {
+		rewarded = 0
+
+		// Held until commit so users cannot be deleted while their volume is being paid out
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", distributionLockKey); err != nil {
+			return fmt.Errorf("failed to acquire distribution lock: %v", err)
+		}
+
+		// Share pool rows are stamped with the end of their week, which marks the week as paid out
+		var distributed bool
+		err := tx.QueryRow(`
+            SELECT EXISTS (SELECT 1 FROM points_history WHERE reason = '`+ReasonWeeklySharePool+`' AND timestamp = $1)
+        `, weekEnd).Scan(&distributed)
+		if err != nil {
+			return fmt.Errorf("failed to check existing distribution: %v", err)
+		}
+		if distributed {
+			log.Printf("Week %d share pool already distributed, skipping", week)
+			return nil
+		}
+
+		// Get the total swap volume for the week
+		var totalVolume float64
+		err = tx.QueryRow(`
+            SELECT COALESCE(SUM(amount_usd), 0)
+            FROM swap_events
+            WHERE timestamp >= $1 AND timestamp < $2
+        `, weekStart, weekEnd).Scan(&totalVolume)
+		if err != nil {
+			return fmt.Errorf("failed to get total volume: %v", err)
+		}
+
+		if totalVolume == 0 {
+			log.Printf("No swaps in week %d, skipping point distribution", week)
+			return nil
+		}
+
+		totalPoints = config.WeeklyPool(totalVolume)
+		if totalPoints <= 0 {
+			log.Printf("Weekly pool is empty (%s mode), skipping point distribution", config.WeeklyPoolMode)
+			return nil
+		}
+
+		// Fetch all eligible users and their volumes
+		rows, err := tx.Query(`
+            SELECT u.id, u.address, COALESCE(SUM(se.amount_usd), 0) as volume
+            FROM users u
+            LEFT JOIN swap_events se ON u.id = se.user_id AND se.timestamp >= $1 AND se.timestamp < $2
+            WHERE u.onboarding_completed = true
+            GROUP BY u.id, u.address
+            HAVING COALESCE(SUM(se.amount_usd), 0) > 0
+            ORDER BY volume DESC
+        `, weekStart, weekEnd)
+		if err != nil {
+			return fmt.Errorf("failed to query user volumes: %v", err)
+		}
+		defer rows.Close()
+
+		type UserData struct {
+			ID      int
+			Address string
+			Volume  float64
+		}
+
+		var users []UserData
+		for rows.Next() {
+			var user UserData
+			if err := rows.Scan(&user.ID, &user.Address, &user.Volume); err != nil {
+				return fmt.Errorf("failed to scan user data: %v", err)
+			}
+			users = append(users, user)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating over user rows: %v", err)
+		}
+
+		volumes := make([]float64, len(users))
+		for i, user := range users {
+			volumes[i] = user.Volume
+		}
+		shares := distributePoints(volumes, totalPoints)
+		awarded := 0
+
+		// Distribute points
+		for i, user := range users {
+			points := shares[i]
+			if points == 0 {
+				continue
+			}
+
+			_, err = tx.Exec(`
+                INSERT INTO points_history (user_id, points, reason, timestamp)
+                VALUES ($1, $2, $3, $4)
+            `, user.ID, points, ReasonWeeklySharePool, weekEnd)
+			if err != nil {
+				return fmt.Errorf("failed to insert points history for user %s: %v", user.Address, err)
+			}
+
+			log.Printf("Awarded %d points to user %s for Weekly Share Pool Task", points, user.Address)
+			rewarded++
+			awarded += points
+		}
+
+		if err := verifyDistribution(week, awarded, totalPoints, weeklyPoolMaxDiscrepancy); err != nil {
+			return err
+		}
+
+		// Share pool rows are stamped with weekEnd, so this is the leaderboard right after the payout
+		_, err = tx.Exec(`
+            INSERT INTO leaderboard_snapshots (campaign_id, week, rank, address, points)
+            SELECT $1, $2, RANK() OVER (ORDER BY SUM(ph.points) DESC), u.address, SUM(ph.points)
+            FROM points_history ph
+            JOIN users u ON u.id = ph.user_id
+            WHERE ph.timestamp <= $3
+            GROUP BY u.address
+            HAVING SUM(ph.points) > 0
+            ON CONFLICT (campaign_id, week, address) DO NOTHING
+        `, config.ID, week, weekEnd)
+		if err != nil {
+			return fmt.Errorf("failed to write week %d leaderboard snapshot: %v", week, err)
+		}
+
+		if isLastWeek {
+			_, err = tx.Exec("UPDATE campaign_config SET is_active = false WHERE id = $1", config.ID)
+			if err != nil {
+				return fmt.Errorf("failed to deactivate campaign: %v", err)
+			}
+			log.Println("Campaign has ended. Deactivated in the database.")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rewarded > 0 {
+		userTasks.invalidateAll()
+		log.Printf("Weekly share pool points calculated and distributed. Total points: %d, Users rewarded: %d", totalPoints, rewarded)
+	}
+	return nil
+}
+
+// ZeroUserPoints removes all of a user's points by writing an offsetting points_history entry,
+// which also drops them from the leaderboard. Their swaps and history are kept. It returns the
+// points removed, or a wrapped sql.ErrNoRows if there is no such user.
+func ZeroUserPoints(address, reason string) (int64, error) {
+	var removed int64
+	err := withTx(func(tx *sql.Tx) error {
+		removed = 0
+
+		// Lock the user so concurrent awards can't land between the sum and the offset
+		var userID int
+		err := tx.QueryRow("SELECT id FROM users WHERE address = $1 FOR UPDATE", normalizeAddress(address)).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with address %s: %w", address, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		var total int64
+		err = tx.QueryRow("SELECT COALESCE(SUM(points), 0) FROM points_history WHERE user_id = $1", userID).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("failed to sum user points: %w", err)
+		}
+		if total == 0 {
+			return nil
+		}
+
+		_, err = tx.Exec("INSERT INTO points_history (user_id, points, reason, timestamp) VALUES ($1, $2, $3, $4)",
+			userID, -total, ReasonAdminAdjustment+": "+reason, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to record points reset: %w", err)
+		}
+		removed = total
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The user's old total must not be served from a stale leaderboard
+	userTasks.invalidate(address)
+	leaderboardSnapshots.clear()
+	return removed, nil
+}
+
+// distributionLockKey is the Postgres advisory lock held by a weekly distribution transaction
+const distributionLockKey = 7_301_400
+
+// ErrDistributionInProgress is returned by DeleteUser while a weekly distribution is running
+var ErrDistributionInProgress = errors.New("weekly distribution in progress")
+
+// UserDeletion reports the rows removed by DeleteUser
+type UserDeletion struct {
+	SwapEvents         int64
+	PointsHistory      int64
+	SwapAuditsRedacted int64
+}
+
+// redactedSender replaces a deleted user's address in swap audit rows. Migration 000019 only
+// lets audit rows be updated to this value.
+const redactedSender = "redacted"
+
+// DeleteUser removes a user with their swaps and points history, and so their leaderboard
+// entry, in one transaction. It fails with ErrDistributionInProgress rather than waiting
+// while a weekly distribution holds the distribution lock. Append-only swap audit rows are kept
+// with the user's address redacted.
+func DeleteUser(address string) (UserDeletion, error) {
+	var deletion UserDeletion
+	err := withTx(func(tx *sql.Tx) error {
+		deletion = UserDeletion{}
+
+		var locked bool
+		if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", distributionLockKey).Scan(&locked); err != nil {
+			return fmt.Errorf("failed to acquire distribution lock: %w", err)
+		}
+		if !locked {
+			return ErrDistributionInProgress
+		}
+
+		var userID int
+		err := tx.QueryRow("SELECT id FROM users WHERE address = $1 FOR UPDATE", normalizeAddress(address)).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with address %s: %w", address, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		result, err := tx.Exec("DELETE FROM points_history WHERE user_id = $1", userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete points history: %w", err)
+		}
+		if deletion.PointsHistory, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to count deleted points history: %w", err)
+		}
+
+		result, err = tx.Exec("DELETE FROM swap_events WHERE user_id = $1", userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete swap events: %w", err)
+		}
+		if deletion.SwapEvents, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to count deleted swap events: %w", err)
+		}
+
+		result, err = tx.Exec("UPDATE audit_swap_processing SET sender = $1 WHERE sender = $2", redactedSender, normalizeAddress(address))
+		if err != nil {
+			return fmt.Errorf("failed to redact swap audit rows: %w", err)
+		}
+		if deletion.SwapAuditsRedacted, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to count redacted swap audit rows: %w", err)
+		}
+
+		// Past weekly leaderboards keep the other users' ranks as they were
+		if _, err := tx.Exec("DELETE FROM leaderboard_snapshots WHERE address = $1", normalizeAddress(address)); err != nil {
+			return fmt.Errorf("failed to delete leaderboard snapshot entries: %w", err)
+		}
+
+		if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return UserDeletion{}, err
+	}
+
+	userTasks.invalidate(address)
+	leaderboardSnapshots.clear()
+	return deletion, nil
+}
+
+// SwapAudit records how a processed swap was valued and the points decision made for it
+type SwapAudit struct {
+	TxHash      string    `json:"txHash"`
+	BlockNumber uint64    `json:"blockNumber"`
+	LogIndex    uint      `json:"logIndex"`
+	Sender      string    `json:"sender"`
+	Reserve0    string    `json:"reserve0,omitempty"`
+	Reserve1    string    `json:"reserve1,omitempty"`
+	PriceSource string    `json:"priceSource"`
+	USDValue    float64   `json:"usdValue"`
+	Points      int       `json:"points"`
+	RuleVersion string    `json:"ruleVersion"`
+	ProcessedAt time.Time `json:"processedAt"`
+}
+
+// RecordSwapAudit appends a row to the swap audit log
+func RecordSwapAudit(audit SwapAudit) error {
+	_, err := DB().Exec(`
+        INSERT INTO audit_swap_processing
+            (transaction_hash, block_number, log_index, sender, reserve0, reserve1, price_source, usd_value, points, rule_version)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		audit.TxHash, audit.BlockNumber, audit.LogIndex, normalizeAddress(audit.Sender),
+		sql.NullString{String: audit.Reserve0, Valid: audit.Reserve0 != ""},
+		sql.NullString{String: audit.Reserve1, Valid: audit.Reserve1 != ""},
+		audit.PriceSource, audit.USDValue, audit.Points, audit.RuleVersion)
+	if err != nil {
+		return fmt.Errorf("failed to record swap audit for %s: %w", audit.TxHash, err)
+	}
+	return nil
+}
+
+// GetSwapAudit returns every audit row for a transaction, oldest first
+func GetSwapAudit(txHash string) ([]SwapAudit, error) {
+	rows, err := DB().Query(`
+        SELECT transaction_hash, block_number, log_index, sender, reserve0::TEXT, reserve1::TEXT,
+               price_source, usd_value, points, rule_version, processed_at
+        FROM audit_swap_processing
+        WHERE LOWER(transaction_hash) = LOWER($1)
+        ORDER BY processed_at ASC, id ASC`, txHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query swap audit: %w", err)
+	}
+	defer rows.Close()
+
+	audits := []SwapAudit{}
+	for rows.Next() {
+		var audit SwapAudit
+		var reserve0, reserve1 sql.NullString
+		if err := rows.Scan(&audit.TxHash, &audit.BlockNumber, &audit.LogIndex, &audit.Sender, &reserve0, &reserve1,
+			&audit.PriceSource, &audit.USDValue, &audit.Points, &audit.RuleVersion, &audit.ProcessedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan swap audit: %w", err)
+		}
+		audit.Reserve0 = reserve0.String
+		audit.Reserve1 = reserve1.String
+		audit.Sender = checksumAddress(audit.Sender)
+		audits = append(audits, audit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over swap audit rows: %w", err)
+	}
+	return audits, nil
+}
+
+// StoredSwap is a swap as recorded in swap_events
+type StoredSwap struct {
+	TxHash      string    `json:"txHash"`
+	Address     string    `json:"address"`
+	AmountUSD   float64   `json:"amountUSD"`
+	BlockNumber uint64    `json:"blockNumber"`
+	LogIndex    *uint     `json:"logIndex"`
+	Timestamp   time.Time `json:"timestamp"`
+	// Pair is the label of the pool the swap happened in, e.g. "WETH/USDC"; empty if unresolved
+	Pair string `json:"pair"`
+}
+
+// GetSwapsByBlockRange returns a page of the swaps recorded between fromBlock and toBlock
+// inclusive, in block order. Swaps recorded before block numbers were stored are never returned.
+func GetSwapsByBlockRange(fromBlock, toBlock uint64, page Pagination) ([]StoredSwap, error) {
+	rows, err := DB().Query(`
+        SELECT s.transaction_hash, u.address, s.amount_usd, s.block_number, s.log_index, s.timestamp
+        FROM swap_events s
+        JOIN users u ON u.id = s.user_id
+        WHERE s.block_number BETWEEN $1 AND $2
+        ORDER BY s.block_number ASC, s.log_index ASC, s.id ASC
+        LIMIT $3 OFFSET $4`, fromBlock, toBlock, page.Limit, page.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query swaps by block range: %w", err)
+	}
+	defer rows.Close()
+
+	swaps := []StoredSwap{}
+	for rows.Next() {
+		var swap StoredSwap
+		var logIndex sql.NullInt64
+		if err := rows.Scan(&swap.TxHash, &swap.Address, &swap.AmountUSD, &swap.BlockNumber, &logIndex, &swap.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan swap: %w", err)
+		}
+		if logIndex.Valid {
+			index := uint(logIndex.Int64)
+			swap.LogIndex = &index
+		}
+		swap.Address = checksumAddress(swap.Address)
+		swaps = append(swaps, swap)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over swap rows: %w", err)
+	}
+	return swaps, nil
+}
+
+// VolumeIntervals lists the bucket sizes GetVolumeTimeseries accepts
+var VolumeIntervals = []string{"hour", "day", "week"}
+
+// VolumeBucket is the total USD swap volume in one time bucket
+type VolumeBucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	VolumeUSD float64   `json:"volumeUSD"`
+}
+
+func isVolumeInterval(interval string) bool {
+	for _, allowed := range VolumeIntervals {
+		if interval == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// GetVolumeTimeseries returns swap volume in [from, to) grouped into interval buckets,
+// oldest first. Buckets without swaps are omitted.
+func GetVolumeTimeseries(interval string, from, to time.Time) ([]VolumeBucket, error) {
+	if !isVolumeInterval(interval) {
+		return nil, fmt.Errorf("invalid volume interval %q", interval)
+	}
+
+	rows, err := DB().Query(`
+        SELECT date_trunc($1, timestamp) AS bucket, SUM(amount_usd)
+        FROM swap_events
+        WHERE timestamp >= $2 AND timestamp < $3
+        GROUP BY bucket
+        ORDER BY bucket ASC`, interval, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query volume timeseries: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []VolumeBucket{}
+	for rows.Next() {
+		var bucket VolumeBucket
+		if err := rows.Scan(&bucket.Timestamp, &bucket.VolumeUSD); err != nil {
+			return nil, fmt.Errorf("failed to scan volume bucket: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over volume rows: %w", err)
+	}
+	return buckets, nil
+}
+
+// OnboardingStats summarizes onboarding progress across all users
+type OnboardingStats struct {
+	Onboarded    int64                 `json:"onboarded"`
+	NotOnboarded int64                 `json:"notOnboarded"`
+	Amounts      OnboardingAmountStats `json:"amounts"`
+}
+
+// OnboardingAmountStats describes the USD size of the swaps that completed onboarding.
+// Users onboarded manually have no such swap and are not counted.
+type OnboardingAmountStats struct {
+	Count   int64   `json:"count"`
+	Min     float64 `json:"min"`
+	Median  float64 `json:"median"`
+	P90     float64 `json:"p90"`
+	Max     float64 `json:"max"`
+	Average float64 `json:"average"`
+}
+
+// GetOnboardingStats returns how many users have completed onboarding and the distribution
+// of their onboarding swap sizes, taking each user's first qualifying swap
+func GetOnboardingStats() (OnboardingStats, error) {
+	var stats OnboardingStats
+	err := DB().QueryRow(`
+        SELECT COUNT(*) FILTER (WHERE onboarding_completed), COUNT(*) FILTER (WHERE NOT onboarding_completed)
+        FROM users`).Scan(&stats.Onboarded, &stats.NotOnboarded)
+	if err != nil {
+		return OnboardingStats{}, fmt.Errorf("failed to count onboarded users: %w", err)
+	}
+
+	err = DB().QueryRow(`
+        SELECT COUNT(*), COALESCE(MIN(amount), 0),
+               COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY amount), 0),
+               COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY amount), 0),
+               COALESCE(MAX(amount), 0), COALESCE(AVG(amount), 0)
+        FROM (
+            SELECT DISTINCT ON (se.user_id) se.amount_usd AS amount
+            FROM swap_events se
+            JOIN users u ON u.id = se.user_id
+            WHERE u.onboarding_completed AND se.amount_usd >= $1
+            ORDER BY se.user_id, se.timestamp ASC
+        ) onboarding`, OnboardingThresholdUSD).
+		Scan(&stats.Amounts.Count, &stats.Amounts.Min, &stats.Amounts.Median, &stats.Amounts.P90, &stats.Amounts.Max, &stats.Amounts.Average)
+	if err != nil {
+		return OnboardingStats{}, fmt.Errorf("failed to query onboarding amounts: %w", err)
+	}
+	return stats, nil
+}
+
+// WeeklyDistribution summarizes one run of the weekly share pool distribution
+type WeeklyDistribution struct {
+	Week          int       `json:"week"`
+	TotalPoints   int64     `json:"totalPoints"`
+	Recipients    int       `json:"recipients"`
+	DistributedAt time.Time `json:"distributedAt"`
+}
+
+// GetWeeklyDistributions returns the weekly share pool distributions made during campaign, oldest first.
+// Each distribution writes all of its points_history rows with the same timestamp, the end of the
+// week it covers, so the week number is read back from the campaign calendar.
+func GetWeeklyDistributions(campaign CampaignConfig) ([]WeeklyDistribution, error) {
+	rows, err := DB().Query(`
+        SELECT timestamp, SUM(points), COUNT(DISTINCT user_id)
+        FROM points_history
+        WHERE reason = '`+ReasonWeeklySharePool+`' AND timestamp >= $1 AND timestamp <= $2
+        GROUP BY timestamp
+        ORDER BY timestamp ASC`, campaign.StartTime, campaign.EndTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query weekly distributions: %w", err)
+	}
+	defer rows.Close()
+
+	distributions := []WeeklyDistribution{}
+	for rows.Next() {
+		var distribution WeeklyDistribution
+		if err := rows.Scan(&distribution.DistributedAt, &distribution.TotalPoints, &distribution.Recipients); err != nil {
+			return nil, fmt.Errorf("failed to scan weekly distribution: %w", err)
+		}
+		// A week that was never distributed leaves a gap rather than renumbering later weeks
+		distribution.Week, _, _, _ = campaign.CompletedWeek(distribution.DistributedAt)
+		distributions = append(distributions, distribution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over weekly distribution rows: %w", err)
+	}
+	return distributions, nil
+}
+
+// distributePoints splits pool across volumes in proportion to each volume using the
+// largest remainder (Hamilton) method, so the shares always sum to exactly pool.
+// Ties in the remainder go to the earlier volume.
+func distributePoints(volumes []float64, pool int) []int {
+	shares := make([]int, len(volumes))
+
+	var total float64
+	for _, volume := range volumes {
+		total += volume
+	}
+	if total <= 0 || pool <= 0 {
+		return shares
+	}
+
+	remainders := make([]float64, len(volumes))
+	remaining := pool
+	for i, volume := range volumes {
+		quota := volume / total * float64(pool)
+		shares[i] = int(math.Floor(quota))
+		remainders[i] = quota - float64(shares[i])
+		remaining -= shares[i]
+	}
+
+	order := make([]int, len(volumes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; remaining > 0 && len(order) > 0; i = (i + 1) % len(order) {
+		shares[order[i]]++
+		remaining--
+	}
+
+	return shares
+}
+
+func GetCampaignConfig() (CampaignConfig, error) {
+	var config CampaignConfig
+	err := DB().QueryRow("SELECT id, start_time, end_time, is_active, paused, COALESCE(start_block, 0), COALESCE(end_block, 0), weekly_pool_mode, weekly_pool_fraction, onboarding_points_schedule, max_points_per_day_per_user FROM campaign_config ORDER BY id DESC LIMIT 1").
+		Scan(&config.ID, &config.StartTime, &config.EndTime, &config.IsActive, &config.Paused, &config.StartBlock, &config.EndBlock,
+			&config.WeeklyPoolMode, &config.WeeklyPoolFraction, (*pq.Int64Array)(&config.OnboardingSchedule), &config.MaxPointsPerDay)
+	if err != nil {
+		return CampaignConfig{}, fmt.Errorf("failed to get campaign config: %w", err)
+	}
+	return config, nil
+}
+
+func SetCampaignConfig(startTime time.Time) error {
+	endTime := startTime.Add(CampaignWeeks * CampaignWeek)
+	return withTx(func(tx *sql.Tx) error {
+		// Serialize campaign creation so two overlapping campaigns cannot both pass the check
+		if _, err := tx.Exec("LOCK TABLE campaign_config IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return fmt.Errorf("failed to lock campaign config: %w", err)
+		}
+
+		var conflict CampaignConflictError
+		err := tx.QueryRow("SELECT id, start_time, end_time FROM campaign_config WHERE is_active = true AND start_time < $2 AND end_time > $1 ORDER BY id DESC LIMIT 1",
+			startTime, endTime).Scan(&conflict.ID, &conflict.StartTime, &conflict.EndTime)
+		if err == nil {
+			return &conflict
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to check for overlapping campaigns: %w", err)
+		}
+
+		_, err = tx.Exec("INSERT INTO campaign_config (start_time, end_time, is_active) VALUES ($1, $2, $3)",
+			startTime, endTime, true)
+		if err != nil {
+			return fmt.Errorf("failed to set campaign config: %v", err)
+		}
+		return nil
+	})
+}
+
+// CampaignConflictError is returned when a new campaign would overlap an active one;
+// the active campaign must be ended first
+type CampaignConflictError struct {
+	ID        int
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func (e *CampaignConflictError) Error() string {
+	return fmt.Sprintf("campaign overlaps active campaign %d (%s to %s)",
+		e.ID, e.StartTime.Format(time.RFC3339), e.EndTime.Format(time.RFC3339))
+}
+
+// EndCampaign deactivates the current campaign if it is still active
+func EndCampaign() error {
+	_, err := DB().Exec("UPDATE campaign_config SET is_active = false WHERE id = (SELECT id FROM campaign_config ORDER BY id DESC LIMIT 1) AND is_active = true")
+	if err != nil {
+		return fmt.Errorf("failed to end campaign: %w", err)
+	}
+	return nil
+}
+
+// PauseCampaign stops point accrual for the current campaign; swaps are still recorded
+func PauseCampaign() error {
+	return setCampaignPaused(true)
+}
+
+// ResumeCampaign restarts point accrual for the current campaign
+func ResumeCampaign() error {
+	return setCampaignPaused(false)
+}
+
+func setCampaignPaused(paused bool) error {
+	result, err := DB().Exec("UPDATE campaign_config SET paused = $1 WHERE id = (SELECT id FROM campaign_config ORDER BY id DESC LIMIT 1)", paused)
+	if err != nil {
+		return fmt.Errorf("failed to update campaign paused state: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check campaign paused state update: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("failed to update campaign paused state: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func AwardOnboardingPoints(userID, points int) error {
+	return withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+            UPDATE users SET onboarding_completed = true, onboarding_points = $2
+            WHERE id = $1 AND onboarding_completed = false
+        `, userID, points)
+		if err != nil {
+			return fmt.Errorf("failed to award onboarding points: %v", err)
+		}
+
+		onboarded, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check onboarding status update: %v", err)
+		}
+		if onboarded == 0 {
+			return nil // Already onboarded
+		}
+
+		_, err = tx.Exec(`
+            INSERT INTO points_history (user_id, points, reason, timestamp)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (user_id) WHERE reason = '`+ReasonOnboarding+`' DO NOTHING
+        `, userID, points, ReasonOnboarding, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to record onboarding points: %v", err)
+		}
+		return nil
+	})
 }
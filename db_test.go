@@ -1,13 +1,43 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
+// campaignConfigQuery matches the query issued by GetCampaignConfig
+const campaignConfigQuery = "SELECT id, start_time, end_time, is_active, paused, (.+) FROM campaign_config"
+
+// campaignConfigRows returns a campaign_config row with no block range for GetCampaignConfig
+func campaignConfigRows(startTime, endTime time.Time, isActive, paused bool) *sqlmock.Rows {
+	return campaignConfigRowsWithBlocks(startTime, endTime, isActive, paused, 0, 0)
+}
+
+func campaignConfigRowsWithBlocks(startTime, endTime time.Time, isActive, paused bool, startBlock, endBlock uint64) *sqlmock.Rows {
+	return campaignConfigRowsFor(CampaignConfig{
+		ID: 1, StartTime: startTime, EndTime: endTime, IsActive: isActive, Paused: paused,
+		StartBlock: startBlock, EndBlock: endBlock, WeeklyPoolMode: PoolModeFixed,
+	})
+}
+
+// campaignConfigRowsFor returns the campaign_config row GetCampaignConfig reads for config
+func campaignConfigRowsFor(config CampaignConfig) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "start_time", "end_time", "is_active", "paused", "start_block", "end_block",
+		"weekly_pool_mode", "weekly_pool_fraction", "onboarding_points_schedule", "max_points_per_day_per_user"}).
+		AddRow(config.ID, config.StartTime, config.EndTime, config.IsActive, config.Paused, config.StartBlock, config.EndBlock,
+			config.WeeklyPoolMode, config.WeeklyPoolFraction, pq.Int64Array(config.OnboardingSchedule), config.MaxPointsPerDay)
+}
+
 func TestGetCampaignConfig(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -15,12 +45,11 @@ func TestGetCampaignConfig(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
 
-	rows := sqlmock.NewRows([]string{"id", "start_time", "end_time", "is_active"}).
-		AddRow(1, time.Now(), time.Now().Add(4*7*24*time.Hour), true)
+	rows := campaignConfigRows(time.Now(), time.Now().Add(4*7*24*time.Hour), true, false)
 
-	mock.ExpectQuery("SELECT id, start_time, end_time, is_active FROM campaign_config").
+	mock.ExpectQuery(campaignConfigQuery).
 		WillReturnRows(rows)
 
 	config, err := GetCampaignConfig()
@@ -40,12 +69,14 @@ func TestRecordSwap(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
+
+	// The swap and its onboarding points are stored at the block time, not the processing time
+	blockTime := time.Now().Add(-10 * time.Minute).UTC()
 
 	// Mock the GetCampaignConfig call
-	mock.ExpectQuery("SELECT id, start_time, end_time, is_active FROM campaign_config").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "start_time", "end_time", "is_active"}).
-			AddRow(1, time.Now(), time.Now().Add(4*7*24*time.Hour), true))
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-time.Hour), time.Now().Add(4*7*24*time.Hour), true, false))
 
 	// Mock the insert or get user query
 	mock.ExpectQuery("INSERT INTO users").
@@ -54,19 +85,131 @@ func TestRecordSwap(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO swap_events").
-		WithArgs(1, "0xabcdef1234567890", 1000.0, sqlmock.AnyArg()).
+		WithArgs(1, "0xabcdef1234567890", 1000.0, blockTime, uint64(12345), uint(3)).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT onboarding_completed FROM users").
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"onboarding_completed"}).AddRow(false))
-	mock.ExpectExec("UPDATE users SET onboarding_completed").
+	mock.ExpectExec("UPDATE users SET onboarding_completed = true, onboarding_points = \\$2 WHERE id = \\$1 AND onboarding_completed = false").
+		WithArgs(1, OnboardingPoints).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(1, OnboardingPoints, blockTime).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
-	err = RecordSwap("0x1234567890123456789012345678901234567890", 1000.0, "0xabcdef1234567890")
+	points, recorded, err := RecordSwap("0x1234567890123456789012345678901234567890", 1000.0, "0xabcdef1234567890", 12345, 3, blockTime)
 	assert.NoError(t, err)
+	assert.Equal(t, OnboardingPoints, points)
+	assert.True(t, recorded)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRecordSwapSeveralSwapsInOneTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// A transaction routed through the pair twice emits two Swap logs; both are recorded
+	for _, logIndex := range []uint{4, 9} {
+		mock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(time.Now().Add(-time.Hour), time.Now().Add(4*7*24*time.Hour), true, false))
+		mock.ExpectQuery("INSERT INTO users").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO swap_events .* ON CONFLICT \\(transaction_hash, log_index\\) DO NOTHING").
+			WithArgs(1, "0xabcdef1234567890", 50.0, sqlmock.AnyArg(), uint64(12345), logIndex).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		_, recorded, err := RecordSwap("0x1234567890123456789012345678901234567890", 50.0, "0xabcdef1234567890", 12345, logIndex, time.Now())
+		assert.NoError(t, err)
+		assert.True(t, recorded)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRecordSwapSkipsLegacySwap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// The swap was recorded before log indexes were stored, so re-scanning its block finds a
+	// NULL log_index row for the transaction and inserts nothing
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-time.Hour), time.Now().Add(4*7*24*time.Hour), true, false))
+	mock.ExpectQuery("INSERT INTO users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO swap_events .* WHERE NOT EXISTS \\(SELECT 1 FROM swap_events WHERE transaction_hash = \\$2 AND log_index IS NULL\\) ON CONFLICT").
+		WithArgs(1, "0xabcdef1234567890", 1000.0, sqlmock.AnyArg(), uint64(12345), uint(3)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	points, recorded, err := RecordSwap("0x1234567890123456789012345678901234567890", 1000.0, "0xabcdef1234567890", 12345, 3, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, recorded)
+	assert.Zero(t, points)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRecordSwapOnboardingSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// Week 1 awards 200 points, week 2 150, and later weeks fall back to the default. The
+	// campaign is in week 3, so a backfilled week 1 swap still gets week 1 points.
+	schedule := []int64{200, 150}
+	start := time.Now().Add(-2*CampaignWeek - time.Hour)
+	for _, tc := range []struct {
+		week   int
+		points int
+	}{
+		{week: 1, points: 200},
+		{week: 3, points: OnboardingPoints},
+	} {
+		blockTime := start.Add(time.Duration(tc.week-1)*CampaignWeek + time.Minute)
+		mock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRowsFor(CampaignConfig{
+				ID: 1, StartTime: start, EndTime: start.Add(4 * CampaignWeek), IsActive: true,
+				WeeklyPoolMode: PoolModeFixed, OnboardingSchedule: schedule,
+			}))
+		mock.ExpectQuery("INSERT INTO users").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO swap_events").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE users SET onboarding_completed").
+			WithArgs(1, tc.points).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO points_history").
+			WithArgs(1, tc.points, blockTime).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		points, _, err := RecordSwap("0x1234567890123456789012345678901234567890", 1000.0, fmt.Sprintf("0xweek%d", tc.week), 12345, 0, blockTime)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.points, points, "week %d", tc.week)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -80,13 +223,16 @@ func TestCalculateWeeklySharePoolPoints(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
 
-	mock.ExpectQuery("SELECT id, start_time, end_time, is_active FROM campaign_config").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "start_time", "end_time", "is_active"}).
-			AddRow(1, time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true))
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true, false))
 
 	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 	mock.ExpectQuery("SELECT COALESCE").
 		WillReturnRows(sqlmock.NewRows([]string{"total_volume"}).AddRow(10000.0))
 	mock.ExpectQuery("SELECT u.id, u.address, COALESCE").
@@ -99,6 +245,55 @@ func TestCalculateWeeklySharePoolPoints(t *testing.T) {
 	mock.ExpectExec("INSERT INTO points_history").
 		WithArgs(2, 5000, "Weekly Share Pool Task", sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO leaderboard_snapshots").
+		WithArgs(1, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err = CalculateWeeklySharePoolPoints()
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCalculateWeeklySharePoolPointsVolumeFraction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRowsFor(CampaignConfig{
+			ID: 1, StartTime: time.Now().Add(-7 * 24 * time.Hour), EndTime: time.Now().Add(21 * 24 * time.Hour), IsActive: true,
+			WeeklyPoolMode: PoolModeVolumeFraction, WeeklyPoolFraction: 0.5,
+		}))
+
+	// The pool is floor(0.5 * 3001) = 1500 points, split exactly by volume
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"total_volume"}).AddRow(3001.0))
+	mock.ExpectQuery("SELECT u.id, u.address, COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "volume"}).
+			AddRow(1, "0x1234", 2000.0).
+			AddRow(2, "0x5678", 1001.0))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(1, 1000, "Weekly Share Pool Task", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(2, 500, "Weekly Share Pool Task", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO leaderboard_snapshots").
+		WithArgs(1, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
 	mock.ExpectCommit()
 
 	err = CalculateWeeklySharePoolPoints()
@@ -108,3 +303,956 @@ func TestCalculateWeeklySharePoolPoints(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestRecordSwapDailyPointsCap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	originalClamped := pointsClampedTotal.Load()
+	defer pointsClampedTotal.Store(originalClamped)
+
+	// The campaign allows 150 points per user per day
+	for _, tc := range []struct {
+		name        string
+		earnedToday int
+		points      int
+	}{
+		{name: "under the cap", earnedToday: 0, points: OnboardingPoints},
+		{name: "partly over the cap", earnedToday: 90, points: 60},
+		{name: "at the cap", earnedToday: 150, points: 0},
+	} {
+		mock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRowsFor(CampaignConfig{
+				ID: 1, StartTime: time.Now().Add(-time.Hour), EndTime: time.Now().Add(4 * CampaignWeek), IsActive: true,
+				WeeklyPoolMode: PoolModeFixed, MaxPointsPerDay: 150,
+			}))
+		mock.ExpectQuery("INSERT INTO users").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO swap_events").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(points\\), 0\\) FROM points_history WHERE user_id = \\$1 AND timestamp >= \\$2 AND reason <> 'Reorg reversal'").
+			WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(tc.earnedToday))
+		if tc.points > 0 {
+			mock.ExpectExec("UPDATE users SET onboarding_completed").
+				WithArgs(1, tc.points).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO points_history").
+				WithArgs(1, tc.points, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		// At the cap onboarding is left incomplete so a swap on a later day can still complete it
+		mock.ExpectCommit()
+
+		points, _, err := RecordSwap("0x1234567890123456789012345678901234567890", 1000.0, "0x"+strings.ReplaceAll(tc.name, " ", ""), 12345, 0, time.Now())
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.points, points, tc.name)
+	}
+	assert.Equal(t, originalClamped+2, pointsClampedTotal.Load())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCampaignConfigWeeklyPool(t *testing.T) {
+	assert.Equal(t, WeeklyPoolPoints, CampaignConfig{WeeklyPoolMode: PoolModeFixed}.WeeklyPool(123456))
+	assert.Equal(t, WeeklyPoolPoints, CampaignConfig{}.WeeklyPool(123456))
+	assert.Equal(t, 1234, CampaignConfig{WeeklyPoolMode: PoolModeVolumeFraction, WeeklyPoolFraction: 0.01}.WeeklyPool(123456))
+}
+
+func TestCampaignConfigWeeks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := CampaignConfig{StartTime: start, EndTime: start.Add(4 * 7 * 24 * time.Hour)}
+
+	assert.Equal(t, 4, config.TotalWeeks())
+	assert.Equal(t, 0, config.CurrentWeek(start.Add(-time.Hour)))
+	assert.Equal(t, 1, config.CurrentWeek(start))
+	assert.Equal(t, 1, config.CurrentWeek(start.Add(7*24*time.Hour-time.Second)))
+	assert.Equal(t, 2, config.CurrentWeek(start.Add(7*24*time.Hour)))
+	assert.Equal(t, 4, config.CurrentWeek(start.Add(60*24*time.Hour)))
+}
+
+func TestRecordSwapWhilePaused(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now(), time.Now().Add(4*7*24*time.Hour), true, true))
+
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("0x1234567890123456789012345678901234567890").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	// The raw swap is still recorded, but no onboarding check or points are written
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO swap_events").
+		WithArgs(1, "0xabcdef1234567890", 5000.0, sqlmock.AnyArg(), uint64(12345), uint(3)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	points, _, err := RecordSwap("0x1234567890123456789012345678901234567890", 5000.0, "0xabcdef1234567890", 12345, 3, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, points)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPauseAndResumeCampaign(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectExec("UPDATE campaign_config SET paused").
+		WithArgs(true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE campaign_config SET paused").
+		WithArgs(false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, PauseCampaign())
+	assert.NoError(t, ResumeCampaign())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCampaignConfigContainsBlock(t *testing.T) {
+	config := CampaignConfig{StartBlock: 100, EndBlock: 200}
+	assert.False(t, config.ContainsBlock(99))
+	assert.True(t, config.ContainsBlock(100))
+	assert.True(t, config.ContainsBlock(200))
+	assert.False(t, config.ContainsBlock(201))
+
+	unbounded := CampaignConfig{}
+	assert.True(t, unbounded.ContainsBlock(0))
+	assert.True(t, unbounded.ContainsBlock(1<<40))
+}
+
+func TestRecordSwapConcurrentOnboardingAwardsOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+	mock.MatchExpectationsInOrder(false)
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(time.Now(), time.Now().Add(4*7*24*time.Hour), true, false))
+		mock.ExpectQuery("INSERT INTO users").
+			WithArgs("0x1234567890123456789012345678901234567890").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO swap_events").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	// The database lets only one of the guarded updates through
+	mock.ExpectExec("UPDATE users SET onboarding_completed").
+		WithArgs(1, OnboardingPoints).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET onboarding_completed").
+		WithArgs(1, OnboardingPoints).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(1, OnboardingPoints, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	var wg sync.WaitGroup
+	awarded := make([]int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			points, _, err := RecordSwap("0x1234567890123456789012345678901234567890", 1500.0, fmt.Sprintf("0xtx%d", i), uint64(100+i), 0, time.Now())
+			assert.NoError(t, err)
+			awarded[i] = points
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, OnboardingPoints, awarded[0]+awarded[1])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAwardOnboardingPointsAlreadyOnboarded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET onboarding_completed = true").
+		WithArgs(1, OnboardingPoints).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// Nothing was written, so committing the transaction is a no-op
+	mock.ExpectCommit()
+
+	assert.NoError(t, AwardOnboardingPoints(1, OnboardingPoints))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUsersByAddresses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	existing := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	missing := "0x1234567890123456789012345678901234567890"
+
+	mock.ExpectQuery("SELECT u.id, u.address, u.onboarding_completed, u.onboarding_points, (.+) FROM users u WHERE u.address = ANY\\(\\$1\\)").
+		WithArgs(pq.Array([]string{strings.ToLower(existing), missing})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "onboarding_completed", "onboarding_points", "total_points"}).
+			AddRow(1, strings.ToLower(existing), true, 100, 5100))
+
+	users, err := GetUsersByAddresses([]string{existing, missing})
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	user, ok := users[strings.ToLower(existing)]
+	assert.True(t, ok)
+	assert.Equal(t, User{ID: 1, Address: strings.ToLower(existing), OnboardingCompleted: true, OnboardingPoints: 100, TotalPoints: 5100}, user)
+
+	_, ok = users[missing]
+	assert.False(t, ok)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUserSummary(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+	// Tasks, total, rank and history are read in one transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, onboarding_completed, onboarding_points, COALESCE").
+		WithArgs(strings.ToLower(address)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "onboarding_completed", "onboarding_points", "onboarding_amount"}).
+			AddRow(1, true, 100, 1000.0))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), COALESCE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount", "share_pool_points", "total_points"}).
+			AddRow(5000.0, 500, 600))
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true, false))
+	mock.ExpectQuery("SELECT MAX\\(timestamp\\) FROM points_history").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Now()))
+	mock.ExpectQuery("WITH totals AS (.+) FROM users u LEFT JOIN totals t ON t.user_id = u.id WHERE u.address = \\$1").
+		WithArgs(strings.ToLower(address)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "points", "rank"}).AddRow(1, 600, 3))
+	mock.ExpectQuery("SELECT points, reason, timestamp FROM points_history WHERE user_id = \\$1 ORDER BY timestamp DESC LIMIT \\$2").
+		WithArgs(1, summaryHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"points", "reason", "timestamp"}).
+			AddRow(500, "Weekly Share Pool Task", "2024-01-08T00:00:00Z").
+			AddRow(100, "Onboarding task completed", "2024-01-01T00:00:00Z"))
+	mock.ExpectRollback()
+
+	summary, err := GetUserSummary(address)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), summary.TotalPoints)
+	if assert.NotNil(t, summary.Rank) {
+		assert.Equal(t, 3, *summary.Rank)
+	}
+	assert.Len(t, summary.RecentPoints, 2)
+	assert.Equal(t, 500, summary.RecentPoints[0]["points"])
+	assert.Equal(t, address, summary.Tasks["address"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDistributePoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes []float64
+		want    []int
+	}{
+		{"even split", []float64{500, 500}, []int{5000, 5000}},
+		{"thirds", []float64{1, 1, 1}, []int{3334, 3333, 3333}},
+		{"largest remainder wins", []float64{2, 3, 5, 1}, []int{1818, 2727, 4546, 909}},
+		{"tiny volume", []float64{1_000_000, 1}, []int{10000, 0}},
+		{"sevenths", []float64{1, 2, 4}, []int{1429, 2857, 5714}},
+		{"single user", []float64{123.45}, []int{10000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := distributePoints(tt.volumes, WeeklyPoolPoints)
+			assert.Equal(t, tt.want, shares)
+
+			sum := 0
+			for _, points := range shares {
+				sum += points
+			}
+			assert.Equal(t, WeeklyPoolPoints, sum)
+		})
+	}
+
+	assert.Empty(t, distributePoints(nil, WeeklyPoolPoints))
+}
+
+// Stored reasons are matched by the onboarding unique index and existing rows, so changing
+// a reason constant needs a migration as well
+func TestPointsReasons(t *testing.T) {
+	assert.Equal(t, "Onboarding task completed", ReasonOnboarding)
+	assert.Equal(t, "Weekly Share Pool Task", ReasonWeeklySharePool)
+}
+
+func TestSetDBConcurrentAccess(t *testing.T) {
+	original := DB()
+	defer SetDB(original)
+
+	first, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer first.Close()
+	second, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer second.Close()
+
+	// Run with -race: swapping the handle while other goroutines read it must be safe
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				SetDB(first)
+			} else {
+				SetDB(second)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			db := DB()
+			assert.True(t, db == original || db == first || db == second)
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, DB() == first || DB() == second)
+}
+
+func TestVerifyDistribution(t *testing.T) {
+	assert.NoError(t, verifyDistribution(1, WeeklyPoolPoints, WeeklyPoolPoints, 0))
+
+	// A distribution that lost points to truncation is detected
+	assert.Error(t, verifyDistribution(1, WeeklyPoolPoints-2, WeeklyPoolPoints, 0))
+	assert.Error(t, verifyDistribution(1, WeeklyPoolPoints+1, WeeklyPoolPoints, 0))
+	assert.NoError(t, verifyDistribution(1, WeeklyPoolPoints-2, WeeklyPoolPoints, 2))
+
+	// With no threshold the discrepancy is only logged
+	assert.NoError(t, verifyDistribution(1, 0, WeeklyPoolPoints, -1))
+
+	assert.Equal(t, -1, parseMaxDiscrepancy(""))
+	assert.Equal(t, -1, parseMaxDiscrepancy("-3"))
+	assert.Equal(t, 5, parseMaxDiscrepancy("5"))
+}
+
+func TestGetLeaderboard(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history ph JOIN users u ON u.id = ph.user_id GROUP BY u.address HAVING SUM\\(ph.points\\) > 0 ORDER BY points DESC, u.address ASC").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x1234567890123456789012345678901234567890", 300).
+			AddRow("0x0987654321098765432109876543210987654321", 300).
+			AddRow("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 100))
+
+	entries, err := GetLeaderboard(Pagination{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []LeaderboardEntry{
+		{Rank: 1, Address: "0x1234567890123456789012345678901234567890", Points: 300},
+		{Rank: 1, Address: "0x0987654321098765432109876543210987654321", Points: 300},
+	}, entries)
+
+	// A later page keeps the overall ranks
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x1234567890123456789012345678901234567890", 300).
+			AddRow("0x0987654321098765432109876543210987654321", 300).
+			AddRow("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 100))
+
+	entries, err = GetLeaderboard(Pagination{Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []LeaderboardEntry{
+		{Rank: 1, Address: "0x0987654321098765432109876543210987654321", Points: 300},
+		{Rank: 3, Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", Points: 100},
+	}, entries)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCountHelpers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM \\( SELECT user_id FROM points_history GROUP BY user_id HAVING SUM\\(points\\) > 0 \\)").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(17))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM swap_events WHERE user_id = \\(SELECT id FROM users WHERE address = \\$1\\)").
+		WithArgs("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnError(sql.ErrConnDone)
+
+	users, err := CountUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), users)
+
+	ranked, err := CountLeaderboard()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(17), ranked)
+
+	swaps, err := CountUserSwaps("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), swaps)
+
+	_, err = CountUsers()
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetLeaderboardTieBreakEarliest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	originalTieBreak := leaderboardTieBreak
+	defer func() { leaderboardTieBreak = originalTieBreak }()
+	leaderboardTieBreak = TieBreakEarliest
+
+	// The same tied rows come back in the same order on every query
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("GROUP BY u.address HAVING SUM\\(ph.points\\) > 0 ORDER BY points DESC, MAX\\(ph.timestamp\\) ASC, u.address ASC").
+			WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+				AddRow("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 300).
+				AddRow("0x0987654321098765432109876543210987654321", 300))
+
+		entries, err := GetLeaderboard(Pagination{Limit: 10})
+		assert.NoError(t, err)
+		assert.Equal(t, []LeaderboardEntry{
+			{Rank: 1, Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", Points: 300},
+			{Rank: 1, Address: "0x0987654321098765432109876543210987654321", Points: 300},
+		}, entries)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestParseTieBreak(t *testing.T) {
+	assert.Equal(t, TieBreakAddress, parseTieBreak(""))
+	assert.Equal(t, TieBreakEarliest, parseTieBreak(" Earliest "))
+	assert.Equal(t, TieBreakAddress, parseTieBreak("random"))
+	assert.Equal(t, "ORDER BY points DESC, u.address ASC", leaderboardOrderBy(TieBreakAddress))
+}
+
+func TestGetLeaderboardForPeriod(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	end := time.Now()
+	start := end.Add(-CampaignWeek)
+
+	mock.ExpectQuery("SELECT u.address, SUM\\(ph.points\\) AS points FROM points_history ph JOIN users u ON u.id = ph.user_id WHERE ph.timestamp >= \\$1 AND ph.timestamp < \\$2 (.+) LIMIT \\$3").
+		WithArgs(start, end, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"address", "points"}).
+			AddRow("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 2500).
+			AddRow("0x1234567890123456789012345678901234567890", 100))
+
+	entries, err := GetLeaderboardForPeriod(start, end, Pagination{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []LeaderboardEntry{
+		{Rank: 1, Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", Points: 2500},
+		{Rank: 2, Address: "0x1234567890123456789012345678901234567890", Points: 100},
+	}, entries)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckDBHealth(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// Healthy: every table is queryable, including an empty one
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT 1 FROM users LIMIT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectQuery("SELECT 1 FROM swap_events LIMIT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectQuery("SELECT 1 FROM points_history LIMIT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	mock.ExpectQuery("SELECT 1 FROM campaign_config LIMIT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	assert.NoError(t, CheckDBHealth(context.Background()))
+
+	// Missing table: ping succeeds but the query fails
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT 1 FROM users LIMIT 1").WillReturnError(fmt.Errorf(`pq: relation "users" does not exist`))
+	err = CheckDBHealth(context.Background())
+	var healthErr *DBHealthError
+	assert.ErrorAs(t, err, &healthErr)
+	assert.Equal(t, "users", healthErr.Check)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetWeeklyDistributions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	campaign := CampaignConfig{ID: 1, StartTime: start, EndTime: start.Add(CampaignWeeks * CampaignWeek), IsActive: true}
+
+	mock.ExpectQuery("SELECT timestamp, SUM\\(points\\), COUNT\\(DISTINCT user_id\\) FROM points_history WHERE reason = 'Weekly Share Pool Task'").
+		WithArgs(campaign.StartTime, campaign.EndTime).
+		WillReturnRows(sqlmock.NewRows([]string{"timestamp", "sum", "count"}).
+			AddRow(start.Add(CampaignWeek), 10000, 3).
+			AddRow(start.Add(3*CampaignWeek), 10000, 5).
+			AddRow(campaign.EndTime, 10000, 4))
+
+	// Week 2 was never distributed, so the weeks are numbered from their timestamps, not their order
+	distributions, err := GetWeeklyDistributions(campaign)
+	assert.NoError(t, err)
+	assert.Equal(t, []WeeklyDistribution{
+		{Week: 1, TotalPoints: 10000, Recipients: 3, DistributedAt: start.Add(CampaignWeek)},
+		{Week: 3, TotalPoints: 10000, Recipients: 5, DistributedAt: start.Add(3 * CampaignWeek)},
+		{Week: CampaignWeeks, TotalPoints: 10000, Recipients: 4, DistributedAt: campaign.EndTime},
+	}, distributions)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// A nil error commits
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err = withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE users SET onboarding_points = 0")
+		return err
+	})
+	assert.NoError(t, err)
+
+	// A returned error rolls back and is passed through
+	fnErr := fmt.Errorf("validation failed")
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err = withTx(func(tx *sql.Tx) error {
+		return fnErr
+	})
+	assert.ErrorIs(t, err, fnErr)
+
+	// A serialization failure is retried
+	attempts := 0
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	err = withTx(func(tx *sql.Tx) error {
+		attempts++
+		if attempts == 1 {
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUserByAddressNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery("SELECT u.id, u.address, u.onboarding_completed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "onboarding_completed", "onboarding_points", "total_points"}))
+
+	_, err = GetUserByAddress("0x1234567890123456789012345678901234567890")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUserTasksCache(t *testing.T) {
+	cache := newUserTasksCache(5 * time.Second)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	loads := 0
+	load := func(address string) (map[string]interface{}, error) {
+		loads++
+		return map[string]interface{}{"totalPoints": int64(loads)}, nil
+	}
+
+	address := "0x1234567890123456789012345678901234567890"
+
+	// Two rapid calls hit the cache
+	first, err := cache.get(address, load)
+	assert.NoError(t, err)
+	second, err := cache.get(address, load)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loads)
+	assert.Equal(t, first, second)
+
+	// A points update for the address busts the entry
+	cache.invalidate(address)
+	_, err = cache.get(address, load)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loads)
+
+	// Entries expire after the TTL
+	now = now.Add(6 * time.Second)
+	_, err = cache.get(address, load)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, loads)
+
+	// Partial results are not cached
+	partial := func(address string) (map[string]interface{}, error) {
+		loads++
+		return map[string]interface{}{"partial": true}, nil
+	}
+	cache.invalidate(address)
+	_, err = cache.get(address, partial)
+	assert.NoError(t, err)
+	_, err = cache.get(address, partial)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, loads)
+}
+
+func TestRecordSwapInvalidatesUserTasksCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	original := userTasks
+	defer func() { userTasks = original }()
+	userTasks = newUserTasksCache(time.Minute)
+
+	address := "0x1234567890123456789012345678901234567890"
+	_, err = userTasks.get(address, func(string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-24*time.Hour), time.Now().Add(27*24*time.Hour), true, false))
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs(address).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO swap_events").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, _, err = RecordSwap(address, 20, "0xabc", 1, 0, time.Now())
+	assert.NoError(t, err)
+
+	_, cached := userTasks.entries[address]
+	assert.False(t, cached)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCampaignConfigCompletedWeek(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := CampaignConfig{StartTime: start, EndTime: start.Add(4 * CampaignWeek)}
+
+	_, _, _, ok := config.CompletedWeek(start.Add(CampaignWeek - time.Nanosecond))
+	assert.False(t, ok, "week 1 has not ended yet")
+
+	week, weekStart, weekEnd, ok := config.CompletedWeek(start.Add(CampaignWeek))
+	assert.True(t, ok)
+	assert.Equal(t, 1, week)
+	assert.Equal(t, start, weekStart)
+	assert.Equal(t, start.Add(CampaignWeek), weekEnd)
+
+	// A job running a few hours late still gets the week that just ended
+	week, weekStart, weekEnd, _ = config.CompletedWeek(start.Add(2*CampaignWeek + 3*time.Hour))
+	assert.Equal(t, 2, week)
+	assert.Equal(t, start.Add(CampaignWeek), weekStart)
+	assert.Equal(t, start.Add(2*CampaignWeek), weekEnd)
+
+	week, _, _, _ = config.CompletedWeek(start.Add(10 * CampaignWeek))
+	assert.Equal(t, 4, week)
+
+	// A trailing partial week ends with the campaign
+	partial := CampaignConfig{StartTime: start, EndTime: start.Add(3*CampaignWeek + 3*24*time.Hour)}
+	week, _, _, _ = partial.CompletedWeek(start.Add(3*CampaignWeek + 24*time.Hour))
+	assert.Equal(t, 3, week)
+	week, weekStart, weekEnd, _ = partial.CompletedWeek(partial.EndTime.Add(time.Hour))
+	assert.Equal(t, 4, week)
+	assert.Equal(t, start.Add(3*CampaignWeek), weekStart)
+	assert.Equal(t, partial.EndTime, weekEnd)
+}
+
+func TestCalculateWeeklySharePoolPointsUsesCampaignWeek(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	weekEnd := start.Add(CampaignWeek)
+	now := weekEnd.Add(3 * time.Hour) // the job runs late
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(start, start.Add(4*CampaignWeek), true, false))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(weekEnd).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT COALESCE").
+		WithArgs(start, weekEnd).
+		WillReturnRows(sqlmock.NewRows([]string{"total_volume"}).AddRow(100.0))
+	mock.ExpectQuery("SELECT u.id, u.address, COALESCE").
+		WithArgs(start, weekEnd).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "volume"}).AddRow(1, "0x1234", 100.0))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(1, WeeklyPoolPoints, "Weekly Share Pool Task", weekEnd).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// The leaderboard is snapshotted as of the end of week 1
+	mock.ExpectExec("INSERT INTO leaderboard_snapshots").
+		WithArgs(1, 1, weekEnd).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, calculateWeeklySharePoolPoints(now))
+
+	// Running again for the same week distributes nothing
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(start, start.Add(4*CampaignWeek), true, false))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(weekEnd).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectCommit()
+
+	assert.NoError(t, calculateWeeklySharePoolPoints(now.Add(time.Hour)))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCatchUpWeeklySharePools(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// Weeks 1 and 2 ended while the service was down; week 1 was paid before it stopped
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	week1End := start.Add(CampaignWeek)
+	week2End := start.Add(2 * CampaignWeek)
+	now := week2End.Add(5 * time.Hour)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(start, start.Add(4*CampaignWeek), true, false))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(week1End).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(week2End).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT COALESCE").
+		WithArgs(week1End, week2End).
+		WillReturnRows(sqlmock.NewRows([]string{"total_volume"}).AddRow(100.0))
+	mock.ExpectQuery("SELECT u.id, u.address, COALESCE").
+		WithArgs(week1End, week2End).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "volume"}).AddRow(1, "0x1234", 100.0))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(1, WeeklyPoolPoints, "Weekly Share Pool Task", week2End).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO leaderboard_snapshots").
+		WithArgs(1, 2, week2End).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, catchUpWeeklySharePools(now))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteUserRedactsSwapAudit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower := strings.ToLower(address)
+	txHash := "0xabc"
+
+	// Audit rows store the sender lowercase, so every row with the address is matched
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(true))
+	mock.ExpectQuery("SELECT id FROM users WHERE address = \\$1 FOR UPDATE").WithArgs(lower).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("DELETE FROM points_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM swap_events").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE audit_swap_processing SET sender = \\$1 WHERE sender = \\$2").WithArgs(redactedSender, lower).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM leaderboard_snapshots").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	deletion, err := DeleteUser(address)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deletion.SwapAuditsRedacted)
+
+	// The audit trail is still there, without the address
+	mock.ExpectQuery("SELECT (.+) FROM audit_swap_processing").WithArgs(txHash).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_hash", "block_number", "log_index", "sender", "reserve0", "reserve1",
+			"price_source", "usd_value", "points", "rule_version", "processed_at"}).
+			AddRow(txHash, 100, 1, redactedSender, "1", "2", "reserves", 1000.0, 100, "v1", time.Now()).
+			AddRow(txHash, 100, 2, redactedSender, "1", "2", "reserves", 50.0, 0, "v1", time.Now()))
+	audits, err := GetSwapAudit(txHash)
+	assert.NoError(t, err)
+	assert.Len(t, audits, 2)
+	for _, audit := range audits {
+		assert.NotEqual(t, lower, strings.ToLower(audit.Sender))
+	}
+
+	// The immutability trigger lets audit rows be updated to exactly this placeholder
+	migration, err := os.ReadFile("migrations/000019_allow_audit_sender_redaction.up.sql")
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(migration), "NEW.sender = '"+redactedSender+"'")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in ErrorResponse.Code
+const (
+	CodeBadRequest       = "BAD_REQUEST"
+	CodeUnauthorized     = "UNAUTHORIZED"
+	CodeForbidden        = "FORBIDDEN"
+	CodeNotFound         = "NOT_FOUND"
+	CodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	CodeConflict         = "CONFLICT"
+	CodeEthereumError    = "ETHEREUM_ERROR"
+	CodeUnavailable      = "SERVICE_UNAVAILABLE"
+	CodeInternalError    = "INTERNAL_ERROR"
+)
+
+// ErrorResponse is the body of every error returned by the API
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+)
+
+// requestID tags each request with the caller's X-Request-ID, or a generated one,
+// and echoes it back in the response header
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// errorCode maps err to an error code, falling back to the code for status
+func errorCode(err error, status int) string {
+	var ethErr *EthereumError
+	var healthErr *DBHealthError
+	switch {
+	case errors.As(err, &ethErr):
+		return CodeEthereumError
+	case errors.As(err, &healthErr):
+		return CodeUnavailable
+	case errors.Is(err, sql.ErrNoRows):
+		return CodeNotFound
+	}
+
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeInternalError
+	}
+}
+
+// respondError aborts the request with an ErrorResponse. The underlying error is
+// only included in the response in gin debug mode so internals don't leak in production.
+func respondError(c *gin.Context, status int, message string, err error) {
+	resp := ErrorResponse{
+		Code:      errorCode(err, status),
+		Message:   message,
+		RequestID: c.GetString(requestIDKey),
+	}
+	if err != nil && gin.IsDebugging() {
+		resp.Details = err.Error()
+	}
+	c.AbortWithStatusJSON(status, resp)
+}
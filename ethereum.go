@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
@@ -17,19 +21,94 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// Default RPC timeouts, overridable with RPC_TIMEOUT and RPC_LOGS_TIMEOUT
 const (
-	UniswapV2PairAddress   = "0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc" // WETH/USDC pair
-	ChainlinkETHUSDAddress = "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419" // Ethereum Mainnet Chainlink Price Feed address for ETH/USD
+	defaultRPCTimeout     = 15 * time.Second
+	defaultRPCLogsTimeout = 60 * time.Second
+)
+
+// defaultPriceMaxStaleness is how old the Chainlink price may be before it is rejected
+const defaultPriceMaxStaleness = time.Hour
+
+// defaultSwapConfirmations is how many blocks a swap must be buried under before it is counted
+const defaultSwapConfirmations = 5
+
+// Default contract addresses, overridable with PAIR_ADDRESS, CHAINLINK_ETH_USD_ADDRESS and WETH_ADDRESS
+const (
+	defaultPairAddress            = "0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc" // USDC/WETH pair: token0 is USDC, token1 WETH
+	defaultChainlinkETHUSDAddress = "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419" // Ethereum Mainnet Chainlink Price Feed address for ETH/USD
+	defaultWETHAddress            = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2" // Ethereum Mainnet WETH
 )
 
 var (
-	Client       EthereumClient
-	swapEventABI abi.ABI
+	// UniswapV2PairAddress is the pool whose swaps are tracked. Swaps are valued from its WETH
+	// leg, in either token position, with the other token taken as a USD stablecoin.
+	UniswapV2PairAddress = defaultPairAddress
+	// ChainlinkETHUSDAddress is the Chainlink ETH/USD price feed
+	ChainlinkETHUSDAddress = defaultChainlinkETHUSDAddress
+	// WETHAddress identifies the WETH leg of the tracked pair
+	WETHAddress = defaultWETHAddress
+
+	Client EthereumClient
+	// trackedSwapEvent is the V2-shaped swap event of the tracked pool, Uniswap V2's Swap unless
+	// SWAP_EVENT_ABI and SWAP_EVENT_NAME select another
+	trackedSwapEvent abi.Event
+	// swapEventConfigErr is why the configured swap event cannot be used, returned by InitEthereumClient
+	swapEventConfigErr error
 	// getReservesSelector is the function selector for the getReserves() function
 	getReservesSelector = crypto.Keccak256Hash([]byte("getReserves()")).Bytes()[:4]
-	InfuraURL           string
+	// RPCURL is the primary RPC endpoint, from ETH_RPC_URL or built from INFURA_PROJECT_ID
+	RPCURL string
+	// RPCURLs lists the RPC endpoints in failover order; defaults to RPCURL
+	RPCURLs []string
+	// ReservesAtLatestBlock reads pool reserves at the latest block instead of the event's block,
+	// for endpoints that do not serve historical (archive) state
+	ReservesAtLatestBlock bool
+	// RPCTimeout bounds each individual RPC call
+	RPCTimeout = defaultRPCTimeout
+	// RPCLogsTimeout bounds FilterLogs calls, which can be slow over large block ranges
+	RPCLogsTimeout = defaultRPCLogsTimeout
+	// SwapWorkers is how many senders' swaps ProcessSwapEvents handles concurrently; 1 is sequential
+	SwapWorkers = 1
+	// SwapConfirmations keeps the newest blocks out of processing until they are unlikely to be reorged
+	SwapConfirmations uint64 = defaultSwapConfirmations
+	// PriceMaxStaleness is the oldest Chainlink round GetEthereumPrice accepts; 0 disables the check
+	PriceMaxStaleness = defaultPriceMaxStaleness
+	// EthOffline serves canned chain data instead of connecting to an RPC endpoint, from ETH_OFFLINE=true
+	EthOffline bool
+	// rpcConfigErr is why no RPC URL could be resolved, returned by InitEthereumClient
+	rpcConfigErr error
 )
 
+// EthereumError is returned when an RPC call to the Ethereum node fails
+type EthereumError struct {
+	Operation string
+	Err       error
+}
+
+func (e *EthereumError) Error() string {
+	return fmt.Sprintf("ethereum %s failed: %v", e.Operation, e.Err)
+}
+
+func (e *EthereumError) Unwrap() error {
+	return e.Err
+}
+
+// StalePriceError is returned when the Chainlink price has not been updated within PriceMaxStaleness
+type StalePriceError struct {
+	UpdatedAt time.Time
+	MaxAge    time.Duration
+}
+
+func (e *StalePriceError) Error() string {
+	return fmt.Sprintf("chainlink price last updated at %s, older than %s", e.UpdatedAt.UTC().Format(time.RFC3339), e.MaxAge)
+}
+
+// rpcContext returns a context bounded by RPCTimeout
+func rpcContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), RPCTimeout)
+}
+
 // Extend the EthereumClient interface
 type EthereumClient interface {
 	CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error)
@@ -52,35 +131,186 @@ func defaultClientCreator(url string) (EthereumClient, error) {
 }
 
 func init() {
-	projectID := os.Getenv("INFURA_PROJECT_ID")
+	EthOffline = os.Getenv("ETH_OFFLINE") == "true"
+	UniswapV2PairAddress = envAddress("PAIR_ADDRESS", defaultPairAddress)
+	ChainlinkETHUSDAddress = envAddress("CHAINLINK_ETH_USD_ADDRESS", defaultChainlinkETHUSDAddress)
+	WETHAddress = envAddress("WETH_ADDRESS", defaultWETHAddress)
+	// A missing RPC URL is reported by InitEthereumClient, so packages and tests that never
+	// connect do not need one
+	RPCURL, rpcConfigErr = resolveRPCURL(os.Getenv("ETH_RPC_URL"), os.Getenv("INFURA_PROJECT_ID"))
+	RPCURLs = parseRPCURLs(os.Getenv("RPC_URLS"), RPCURL)
+	warnIgnoredRPCURL(os.Getenv("ETH_RPC_URL"), RPCURLs)
+	ReservesAtLatestBlock = os.Getenv("RESERVES_SOURCE") == "latest"
+	RPCTimeout = envDuration("RPC_TIMEOUT", defaultRPCTimeout)
+	RPCLogsTimeout = envDuration("RPC_LOGS_TIMEOUT", defaultRPCLogsTimeout)
+	PriceMaxStaleness = envDuration("PRICE_MAX_STALENESS", defaultPriceMaxStaleness)
+	if workers := os.Getenv("SWAP_WORKERS"); workers != "" {
+		n, err := strconv.Atoi(workers)
+		if err != nil || n < 1 {
+			LogError("Invalid SWAP_WORKERS %q, processing swaps sequentially", workers)
+		} else {
+			SwapWorkers = n
+		}
+	}
+	if confirmations := os.Getenv("SWAP_CONFIRMATIONS"); confirmations != "" {
+		n, err := strconv.ParseUint(confirmations, 10, 64)
+		if err != nil {
+			LogError("Invalid SWAP_CONFIRMATIONS %q, using %d", confirmations, defaultSwapConfirmations)
+		} else {
+			SwapConfirmations = n
+		}
+	}
+}
+
+// envAddress reads a contract address from the environment in checksum form, falling back to
+// defaultAddress when unset or invalid
+func envAddress(name, defaultAddress string) string {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultAddress
+	}
+	if !common.IsHexAddress(value) {
+		LogError("Invalid %s %q, using %s", name, value, defaultAddress)
+		return defaultAddress
+	}
+	return common.HexToAddress(value).Hex()
+}
+
+// resolveRPCURL returns rpcURL when set, otherwise the Infura mainnet URL for projectID
+func resolveRPCURL(rpcURL, projectID string) (string, error) {
+	if rpcURL = strings.TrimSpace(rpcURL); rpcURL != "" {
+		return rpcURL, nil
+	}
 	if projectID == "" {
-		LogFatal("INFURA_PROJECT_ID environment variable is not set")
+		return "", fmt.Errorf("neither ETH_RPC_URL nor INFURA_PROJECT_ID environment variable is set")
+	}
+	return fmt.Sprintf("https://mainnet.infura.io/v3/%s", projectID), nil
+}
+
+// parseRPCURLs splits a comma-separated list of RPC URLs, falling back to defaultURL when empty
+func parseRPCURLs(list, defaultURL string) []string {
+	var urls []string
+	for _, url := range strings.Split(list, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 && defaultURL != "" {
+		urls = []string{defaultURL}
+	}
+	return urls
+}
+
+// warnIgnoredRPCURL logs when ETH_RPC_URL is set but left out of RPC_URLS, which takes precedence.
+// The URLs are not logged since they often embed an API key.
+func warnIgnoredRPCURL(rpcURL string, urls []string) {
+	if rpcURL = strings.TrimSpace(rpcURL); rpcURL == "" {
+		return
+	}
+	for _, url := range urls {
+		if url == rpcURL {
+			return
+		}
 	}
-	InfuraURL = fmt.Sprintf("https://mainnet.infura.io/v3/%s", projectID)
+	LogError("Both RPC_URLS and ETH_RPC_URL are set and ETH_RPC_URL is not in RPC_URLS; using RPC_URLS only")
 }
 
 func InitEthereumClient(creator ClientCreator) error {
+	if swapEventConfigErr != nil {
+		return LogErrorf(swapEventConfigErr, "invalid swap event configuration")
+	}
+	if EthOffline {
+		Client = offlineClient{}
+		LogInfo("ETH_OFFLINE is set, serving canned Ethereum data without an RPC connection")
+		return nil
+	}
 	if creator == nil {
 		creator = defaultClientCreator
 	}
-	var err error
-	Client, err = creator(InfuraURL)
-	if err != nil {
-		return LogErrorf(err, "failed to connect to the Ethereum client")
+	if len(RPCURLs) == 0 {
+		if RPCURL == "" {
+			return LogErrorf(rpcConfigErr, "no Ethereum RPC endpoint configured")
+		}
+		RPCURLs = []string{RPCURL}
+	}
+
+	if len(RPCURLs) == 1 {
+		var err error
+		Client, err = creator(RPCURLs[0])
+		if err != nil {
+			return LogErrorf(err, "failed to connect to the Ethereum client")
+		}
+		LogInfo("Successfully connected to Ethereum client")
+		return nil
 	}
-	LogInfo("Successfully connected to Ethereum client")
+
+	var (
+		urls    []string
+		clients []EthereumClient
+		lastErr error
+	)
+	for _, url := range RPCURLs {
+		client, err := creator(url)
+		if err != nil {
+			LogError("Failed to connect to RPC endpoint %s: %v", url, err)
+			lastErr = err
+			continue
+		}
+		urls = append(urls, url)
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return LogErrorf(lastErr, "failed to connect to any Ethereum RPC endpoint")
+	}
+
+	Client = NewFailoverClient(urls, clients)
+	LogInfo("Successfully connected to %d of %d Ethereum RPC endpoints", len(clients), len(RPCURLs))
 	return nil
 }
 
+// CloseEthereumClient closes the connections behind Client. It is safe to call more than once.
+func CloseEthereumClient() {
+	if closer, ok := Client.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	Client = nil
+}
+
 // SwapEvent represents the data structure of a Swap event
 type SwapEvent struct {
+	// Sender is the indexed sender topic of the Swap log: the account points are credited to
 	Sender     common.Address
 	Amount0In  *big.Int
 	Amount1In  *big.Int
 	Amount0Out *big.Int
 	Amount1Out *big.Int
-	To         common.Address
-	USDValue   *big.Float
+	// To is the indexed to topic of the Swap log: the address that received the output tokens
+	To common.Address
+	// USDValue is encoded by MarshalJSON as usd_value
+	USDValue *big.Float `json:"-"`
+	// SqrtPriceX96 is the pool price after a Uniswap V3 swap; nil for V2 swaps
+	SqrtPriceX96 *big.Int
+	// Pair describes the pool the swap happened in; nil if its tokens could not be resolved
+	Pair *PairMetadata
+}
+
+// MarshalJSON encodes USDValue as usd_value, a decimal string with exactly USDDecimals places
+// rounded like the stored value, e.g. "1000.00", or null when the swap has not been valued.
+// The other fields keep their default encoding.
+func (e SwapEvent) MarshalJSON() ([]byte, error) {
+	type swapEventFields SwapEvent
+	var usdValue *string
+	if e.USDValue != nil {
+		if e.USDValue.IsInf() {
+			return nil, fmt.Errorf("USD value %s cannot be encoded", e.USDValue)
+		}
+		formatted := formatUSD(e.USDValue)
+		usdValue = &formatted
+	}
+	return json.Marshal(struct {
+		swapEventFields
+		USDValue *string `json:"usd_value"`
+	}{swapEventFields(e), usdValue})
 }
 
 // AggregatorV3Interface is a simplified ABI of the Chainlink Price Feed contract
@@ -90,26 +320,38 @@ type AggregatorV3Interface struct {
 
 // GetEthereumPrice fetches the latest ETH/USD price from Chainlink Price Feed
 func GetEthereumPrice() (*big.Float, error) {
+	quote, err := GetEthereumPriceQuote()
+	if err != nil {
+		return nil, err
+	}
+	return quote.Price, nil
+}
+
+// fetchEthereumPrice calls latestRoundData on the Chainlink ETH/USD feed
+func fetchEthereumPrice() (PriceQuote, error) {
 	address := common.HexToAddress(ChainlinkETHUSDAddress)
 
 	// ABI for the latestRoundData function
 	const abiJSON = `[{"inputs":[],"name":"latestRoundData","outputs":[{"internalType":"uint80","name":"roundId","type":"uint80"},{"internalType":"int256","name":"answer","type":"int256"},{"internalType":"uint256","name":"startedAt","type":"uint256"},{"internalType":"uint256","name":"updatedAt","type":"uint256"},{"internalType":"uint80","name":"answeredInRound","type":"uint80"}],"stateMutability":"view","type":"function"}]`
 	parsedABI, err := abi.JSON(strings.NewReader(abiJSON))
 	if err != nil {
-		return nil, LogErrorf(err, "failed to parse ABI")
+		return PriceQuote{}, LogErrorf(err, "failed to parse ABI")
 	}
 
 	data, err := parsedABI.Pack("latestRoundData")
 	if err != nil {
-		return nil, LogErrorf(err, "failed to pack data for latestRoundData function call")
+		return PriceQuote{}, LogErrorf(err, "failed to pack data for latestRoundData function call")
 	}
 
-	result, err := Client.CallContract(context.Background(), ethereum.CallMsg{
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	result, err := Client.CallContract(ctx, ethereum.CallMsg{
 		To:   &address,
 		Data: data,
 	}, nil)
 	if err != nil {
-		return nil, LogErrorf(err, "failed to call latestRoundData function")
+		return PriceQuote{}, LogErrorf(&EthereumError{Operation: "latestRoundData", Err: err}, "failed to call latestRoundData function")
 	}
 
 	var (
@@ -122,14 +364,25 @@ func GetEthereumPrice() (*big.Float, error) {
 
 	err = parsedABI.UnpackIntoInterface(&[]interface{}{&roundId, &answer, &startedAt, &updatedAt, &answeredInRound}, "latestRoundData", result)
 	if err != nil {
-		return nil, LogErrorf(err, "failed to unpack result")
+		// A malformed response is an RPC failure too, e.g. a node returning empty data
+		return PriceQuote{}, LogErrorf(&EthereumError{Operation: "latestRoundData", Err: err}, "failed to unpack result")
+	}
+
+	var lastUpdate time.Time
+	if updatedAt != nil {
+		lastUpdate = time.Unix(updatedAt.Int64(), 0)
+	}
+
+	// During a feed outage the last answer keeps being served; don't value swaps with it
+	if PriceMaxStaleness > 0 && updatedAt != nil && time.Since(lastUpdate) > PriceMaxStaleness {
+		return PriceQuote{}, LogErrorf(&StalePriceError{UpdatedAt: lastUpdate, MaxAge: PriceMaxStaleness}, "rejecting Chainlink ETH price")
 	}
 
 	// Chainlink price feeds for ETH/USD use 8 decimal places
 	ethPrice := new(big.Float).SetInt(answer)
 	ethPrice = ethPrice.Quo(ethPrice, big.NewFloat(1e8))
 
-	return ethPrice, nil
+	return PriceQuote{Price: ethPrice, UpdatedAt: lastUpdate}, nil
 }
 
 // NewAggregatorV3Interface creates a new instance of AggregatorV3Interface
@@ -153,17 +406,118 @@ func NewAggregatorV3Interface(address common.Address, backend bind.ContractBacke
 	}, nil
 }
 
-// Swap event signature
-var SwapEventSignature = []byte("Swap(address,uint256,uint256,uint256,uint256,address)")
+// Swap event signatures of Uniswap V2 pairs and V3 pools
+var (
+	SwapEventSignature   = []byte("Swap(address,uint256,uint256,uint256,uint256,address)")
+	SwapEventV3Signature = []byte("Swap(address,address,int256,int256,uint160,uint128,int24)")
+)
+
+// Pool versions selectable with POOL_VERSION
+const (
+	PoolVersionV2 = "v2"
+	PoolVersionV3 = "v3"
+)
+
+// PoolVersion is the Uniswap version of the tracked pool, which decides how Swap logs are parsed
+var PoolVersion = PoolVersionV2
+
+// swapEventV3ABI decodes Uniswap V3 Swap logs
+var swapEventV3ABI abi.ABI
+
+// swapEventV3 is the non-indexed data of a Uniswap V3 Swap log. Positive amounts entered the
+// pool and negative amounts left it.
+type swapEventV3 struct {
+	Amount0      *big.Int
+	Amount1      *big.Int
+	SqrtPriceX96 *big.Int
+	Liquidity    *big.Int
+	Tick         *big.Int
+}
+
+// swapEventTopic returns the Swap event topic for PoolVersion
+func swapEventTopic() common.Hash {
+	if PoolVersion == PoolVersionV3 {
+		return crypto.Keccak256Hash(SwapEventV3Signature)
+	}
+	return trackedSwapEvent.ID
+}
+
+// uniswapV2SwapABI is the ABI of the Uniswap V2 pair Swap event
+const uniswapV2SwapABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"sender","type":"address"},{"indexed":false,"name":"amount0In","type":"uint256"},{"indexed":false,"name":"amount1In","type":"uint256"},{"indexed":false,"name":"amount0Out","type":"uint256"},{"indexed":false,"name":"amount1Out","type":"uint256"},{"indexed":true,"name":"to","type":"address"}],"name":"Swap","type":"event"}]`
+
+// parseSwapEvent finds the event name in abiJSON and checks it has the shape of a Uniswap V2
+// Swap: indexed sender and to addresses, then four uint256 amounts in the order amount0In,
+// amount1In, amount0Out, amount1Out under any names. A non-empty signature must match the
+// event's canonical signature, e.g. "Swap(address,uint256,uint256,uint256,uint256,address)".
+func parseSwapEvent(abiJSON, name, signature string) (abi.Event, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return abi.Event{}, fmt.Errorf("invalid swap event ABI: %w", err)
+	}
+	event, ok := parsed.Events[name]
+	if !ok {
+		return abi.Event{}, fmt.Errorf("swap event ABI has no event %q", name)
+	}
+	if signature != "" && strings.ReplaceAll(signature, " ", "") != event.Sig {
+		return abi.Event{}, fmt.Errorf("swap event signature %q does not match the ABI event %s", signature, event.Sig)
+	}
+	if event.Anonymous {
+		return abi.Event{}, fmt.Errorf("swap event %s is anonymous, so its logs cannot be filtered by topic", event.Sig)
+	}
+
+	var addresses, amounts int
+	for _, input := range event.Inputs {
+		switch {
+		case input.Indexed && input.Type.T == abi.AddressTy:
+			addresses++
+		case !input.Indexed && input.Type.T == abi.UintTy && input.Type.Size == 256:
+			amounts++
+		default:
+			return abi.Event{}, fmt.Errorf("swap event %s input %q is not an indexed address or uint256 amount", event.Sig, input.Name)
+		}
+	}
+	if addresses != 2 || amounts != 4 {
+		return abi.Event{}, fmt.Errorf("swap event %s must have 2 indexed addresses and 4 uint256 amounts", event.Sig)
+	}
+	return event, nil
+}
 
 func init() {
-	// Initialize the ABI for the Swap event
-	const abiJSON = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"sender","type":"address"},{"indexed":false,"name":"amount0In","type":"uint256"},{"indexed":false,"name":"amount1In","type":"uint256"},{"indexed":false,"name":"amount0Out","type":"uint256"},{"indexed":false,"name":"amount1Out","type":"uint256"},{"indexed":true,"name":"to","type":"address"}],"name":"Swap","type":"event"}]`
 	var err error
-	swapEventABI, err = abi.JSON(strings.NewReader(abiJSON))
+	trackedSwapEvent, err = parseSwapEvent(uniswapV2SwapABI, "Swap", string(SwapEventSignature))
+	if err != nil {
+		panic(err)
+	}
+	// A custom event for forks with differently named events or arguments; an invalid one is
+	// reported by InitEthereumClient
+	abiJSON, name, signature := os.Getenv("SWAP_EVENT_ABI"), os.Getenv("SWAP_EVENT_NAME"), os.Getenv("SWAP_EVENT_SIGNATURE")
+	if abiJSON != "" || name != "" || signature != "" {
+		if abiJSON == "" {
+			abiJSON = uniswapV2SwapABI
+		}
+		if name == "" {
+			name = "Swap"
+		}
+		if event, err := parseSwapEvent(abiJSON, name, signature); err != nil {
+			swapEventConfigErr = err
+		} else {
+			trackedSwapEvent = event
+		}
+	}
+
+	const v3ABIJSON = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"sender","type":"address"},{"indexed":true,"name":"recipient","type":"address"},{"indexed":false,"name":"amount0","type":"int256"},{"indexed":false,"name":"amount1","type":"int256"},{"indexed":false,"name":"sqrtPriceX96","type":"uint160"},{"indexed":false,"name":"liquidity","type":"uint128"},{"indexed":false,"name":"tick","type":"int24"}],"name":"Swap","type":"event"}]`
+	swapEventV3ABI, err = abi.JSON(strings.NewReader(v3ABIJSON))
 	if err != nil {
 		panic(err)
 	}
+
+	switch version := os.Getenv("POOL_VERSION"); version {
+	case "", PoolVersionV2:
+	case PoolVersionV3:
+		PoolVersion = PoolVersionV3
+	default:
+		LogError("Invalid POOL_VERSION %q, using %s", version, PoolVersionV2)
+	}
 }
 
 func FetchSwapEvents(fromBlock, toBlock *big.Int) ([]types.Log, error) {
@@ -172,12 +526,15 @@ func FetchSwapEvents(fromBlock, toBlock *big.Int) ([]types.Log, error) {
 		FromBlock: fromBlock,
 		ToBlock:   toBlock,
 		Addresses: []common.Address{contractAddress},
-		Topics:    [][]common.Hash{{crypto.Keccak256Hash(SwapEventSignature)}},
+		Topics:    [][]common.Hash{{swapEventTopic()}},
 	}
 
-	logs, err := Client.FilterLogs(context.Background(), query)
+	ctx, cancel := context.WithTimeout(context.Background(), RPCLogsTimeout)
+	defer cancel()
+
+	logs, err := Client.FilterLogs(ctx, query)
 	if err != nil {
-		return nil, LogErrorf(err, "failed to filter logs")
+		return nil, LogErrorf(&EthereumError{Operation: "FilterLogs", Err: err}, "failed to filter logs")
 	}
 
 	LogInfo("Successfully fetched %d swap events from block %s to %s",
@@ -186,36 +543,122 @@ func FetchSwapEvents(fromBlock, toBlock *big.Int) ([]types.Log, error) {
 	return logs, nil
 }
 
-func calculateUSDValue(event *SwapEvent, reserve0, reserve1 *big.Int) (*big.Float, error) {
-	// Assume USDC has 6 decimal places and WETH has 18
-	usdcDecimals := big.NewFloat(1e6)
-	wethDecimals := big.NewFloat(1e18)
+// DefaultBackfillChunkSize is the number of blocks fetched per FilterLogs call during a backfill
+const DefaultBackfillChunkSize = 2000
 
-	// Convert big.Int to big.Float
-	amount0In := new(big.Float).SetInt(event.Amount0In)
-	amount1In := new(big.Float).SetInt(event.Amount1In)
-	amount0Out := new(big.Float).SetInt(event.Amount0Out)
-	amount1Out := new(big.Float).SetInt(event.Amount1Out)
+// BackfillResult summarizes a historical backfill run
+type BackfillResult struct {
+	FromBlock       uint64 `json:"fromBlock"`
+	ToBlock         uint64 `json:"toBlock"`
+	Chunks          int    `json:"chunks"`
+	EventsFetched   int    `json:"eventsFetched"`
+	EventsProcessed int    `json:"eventsProcessed"`
+}
+
+// BackfillSwapEvents fetches and processes swap events for [fromBlock, toBlock] in chunks.
+// Swaps are deduplicated by transaction hash when recorded, so re-running a range is safe.
+// The backfill is independent of the live processing loop and does not affect it.
+func BackfillSwapEvents(fromBlock, toBlock, chunkSize uint64) (BackfillResult, error) {
+	result := BackfillResult{FromBlock: fromBlock, ToBlock: toBlock}
+	if fromBlock > toBlock {
+		return result, fmt.Errorf("invalid block range: fromBlock %d is after toBlock %d", fromBlock, toBlock)
+	}
+	if chunkSize == 0 {
+		chunkSize = DefaultBackfillChunkSize
+	}
+
+	for start := fromBlock; start <= toBlock; start += chunkSize {
+		end := start + chunkSize - 1
+		if end > toBlock || end < start {
+			end = toBlock
+		}
+
+		logs, err := FetchSwapEvents(new(big.Int).SetUint64(start), new(big.Int).SetUint64(end))
+		if err != nil {
+			return result, LogErrorf(err, "backfill failed for blocks %d to %d", start, end)
+		}
+
+		result.EventsFetched += len(logs)
+		if len(logs) > 0 {
+			swapEvents, err := ProcessSwapEvents(logs)
+			result.EventsProcessed += len(swapEvents)
+			if err != nil {
+				return result, LogErrorf(err, "backfill failed for blocks %d to %d", start, end)
+			}
+		}
+		result.Chunks++
 
-	// Adjust for decimals
-	amount0In.Quo(amount0In, wethDecimals)
-	amount1In.Quo(amount1In, usdcDecimals)
-	amount0Out.Quo(amount0Out, wethDecimals)
-	amount1Out.Quo(amount1Out, usdcDecimals)
+		LogInfo("Backfill progress: blocks %d to %d done (%d/%d blocks), %d events fetched, %d processed",
+			start, end, end-fromBlock+1, toBlock-fromBlock+1, result.EventsFetched, result.EventsProcessed)
 
-	// Calculate pool price (USDC per WETH)
-	reserveWETH := new(big.Float).Quo(new(big.Float).SetInt(reserve0), wethDecimals)
-	reserveUSDC := new(big.Float).Quo(new(big.Float).SetInt(reserve1), usdcDecimals)
-	poolPrice := new(big.Float).Quo(reserveUSDC, reserveWETH)
+		if end == toBlock {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func calculateUSDValue(event *SwapEvent, reserve0, reserve1 *big.Int, decimals PairDecimals) (*big.Float, error) {
+	// Calculate pool price (USD per WETH); decimals are read from the token contracts
+	reserveWETH, reserveStable := decimals.legs(reserve0, reserve1)
+	wethReserve := new(big.Float).Quo(new(big.Float).SetInt(reserveWETH), decimals.wethUnit())
+	stableReserve := new(big.Float).Quo(new(big.Float).SetInt(reserveStable), decimals.stableUnit())
+	poolPrice := new(big.Float).Quo(stableReserve, wethReserve)
+
+	return usdValueAtPrice(event, poolPrice, decimals)
+}
+
+// calculateUSDValueFromSqrtPrice values a Uniswap V3 swap at the pool price it left behind,
+// (sqrtPriceX96 / 2^96)^2 scaled by the token decimals
+func calculateUSDValueFromSqrtPrice(event *SwapEvent, sqrtPriceX96 *big.Int, decimals PairDecimals) (*big.Float, error) {
+	// The pool price is token1 per token0; invert it when WETH is token1
+	sqrtPrice := new(big.Float).Quo(new(big.Float).SetInt(sqrtPriceX96), new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 96)))
+	poolPrice := new(big.Float).Mul(sqrtPrice, sqrtPrice)
+	poolPrice.Mul(poolPrice, tokenUnit(decimals.Token0))
+	poolPrice.Quo(poolPrice, tokenUnit(decimals.Token1))
+	if decimals.WETHIndex == 1 {
+		poolPrice.Quo(big.NewFloat(1), poolPrice)
+	}
+
+	return usdValueAtPrice(event, poolPrice, decimals)
+}
+
+// usdValueAtPrice values a swap given the pool price in USD per WETH
+func usdValueAtPrice(event *SwapEvent, poolPrice *big.Float, decimals PairDecimals) (*big.Float, error) {
+	wethUnit := decimals.wethUnit()
+	stableUnit := decimals.stableUnit()
+
+	// Convert the WETH and stablecoin legs to whole tokens
+	rawWETHIn, rawStableIn := decimals.legs(event.Amount0In, event.Amount1In)
+	rawWETHOut, rawStableOut := decimals.legs(event.Amount0Out, event.Amount1Out)
+	wethIn := new(big.Float).Quo(new(big.Float).SetInt(rawWETHIn), wethUnit)
+	stableIn := new(big.Float).Quo(new(big.Float).SetInt(rawStableIn), stableUnit)
+	wethOut := new(big.Float).Quo(new(big.Float).SetInt(rawWETHOut), wethUnit)
+	stableOut := new(big.Float).Quo(new(big.Float).SetInt(rawStableOut), stableUnit)
 
 	// Calculate USD value based on the non-zero input or output
 	var usdValue *big.Float
-	if amount0In.Cmp(big.NewFloat(0)) > 0 {
+	if wethIn.Sign() > 0 && stableIn.Sign() > 0 {
+		// Both tokens were input, as when liquidity is added to the pair in the same transaction.
+		// Value the swap at its dominant leg by USD value rather than summing, so the smaller
+		// leg does not inflate the volume.
+		usdValue = new(big.Float).Mul(wethIn, poolPrice)
+		if stableIn.Cmp(usdValue) > 0 {
+			usdValue = stableIn
+		}
+	} else if wethIn.Sign() > 0 {
 		// WETH was input, calculate USD value based on WETH
-		usdValue = new(big.Float).Mul(amount0In, poolPrice)
-	} else if amount1Out.Cmp(big.NewFloat(0)) > 0 {
-		// USDC was output, use this value directly
-		usdValue = amount1Out
+		usdValue = new(big.Float).Mul(wethIn, poolPrice)
+	} else if stableOut.Sign() > 0 {
+		// The stablecoin was output, use this value directly
+		usdValue = stableOut
+	} else if stableIn.Sign() > 0 {
+		// The stablecoin was input, use this value directly
+		usdValue = stableIn
+	} else if wethOut.Sign() > 0 {
+		// WETH was output, calculate USD value based on WETH
+		usdValue = new(big.Float).Mul(wethOut, poolPrice)
 	} else {
 		return nil, fmt.Errorf("invalid swap event: no input or output")
 	}
@@ -227,80 +670,419 @@ var getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error
 	return getPoolReserves(blockNumber)
 }
 
-func ProcessSwapEvents(logs []types.Log) []*SwapEvent {
+// isMissingArchiveDataError reports whether err means the endpoint cannot serve state for a historical block
+func isMissingArchiveDataError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "missing trie node") ||
+		strings.Contains(msg, "state is not available") ||
+		strings.Contains(msg, "historical state")
+}
+
+// swapValuation records how a swap's USD value was computed
+type swapValuation struct {
+	USDValue    *big.Float
+	Reserve0    *big.Int
+	Reserve1    *big.Int
+	PriceSource string
+}
+
+// calculateSwapUSDValue values a swap from the pool reserves, falling back to the Chainlink
+// ETH price when the endpoint cannot serve historical state for the event's block. V3 swaps
+// carry the pool price in the event and need no RPC call.
+func calculateSwapUSDValue(event *SwapEvent, blockNumber uint64, ethPrice *big.Float, decimals PairDecimals) (swapValuation, error) {
+	if event.SqrtPriceX96 != nil {
+		usdValue, err := calculateUSDValueFromSqrtPrice(event, event.SqrtPriceX96, decimals)
+		return swapValuation{USDValue: usdValue, PriceSource: "sqrtPriceX96"}, err
+	}
+
+	reserve0, reserve1, err := getPoolReservesWrapper(blockNumber)
+	if err != nil {
+		if isMissingArchiveDataError(err) {
+			if ethPrice == nil {
+				return swapValuation{}, fmt.Errorf("historical reserves unavailable for block %d and no fresh Chainlink price to fall back to: %w", blockNumber, err)
+			}
+			LogInfo("Historical reserves unavailable for block %d, falling back to Chainlink ETH price", blockNumber)
+			usdValue, err := calculateUSDValueWithEthPrice(event, ethPrice, decimals)
+			return swapValuation{USDValue: usdValue, PriceSource: "chainlink"}, err
+		}
+		return swapValuation{}, err
+	}
+
+	usdValue, err := calculateUSDValue(event, reserve0, reserve1, decimals)
+	return swapValuation{USDValue: usdValue, Reserve0: reserve0, Reserve1: reserve1, PriceSource: "reserves"}, err
+}
+
+// logSwapProcessed emits one DEBUG record per swap correlating the inputs and outcome of its scoring
+func logSwapProcessed(vLog types.Log, event *SwapEvent, valuation swapValuation, usdValue float64, points int) {
+	fields := Fields{
+		"txHash":      vLog.TxHash.Hex(),
+		"block":       vLog.BlockNumber,
+		"logIndex":    vLog.Index,
+		"sender":      event.Sender.Hex(),
+		"priceSource": valuation.PriceSource,
+		"usdValue":    usdValue,
+		"points":      points,
+	}
+	if valuation.Reserve0 != nil && valuation.Reserve1 != nil {
+		fields["reserve0"] = valuation.Reserve0.String()
+		fields["reserve1"] = valuation.Reserve1.String()
+	}
+	if event.Pair != nil {
+		fields["pair"] = event.Pair.Label
+	}
+	LogDebugFields("Swap processed", fields)
+}
+
+// newSwapAudit builds the audit log row for a processed swap
+func newSwapAudit(vLog types.Log, event *SwapEvent, valuation swapValuation, usdValue float64, points int) SwapAudit {
+	audit := SwapAudit{
+		TxHash:      vLog.TxHash.Hex(),
+		BlockNumber: vLog.BlockNumber,
+		LogIndex:    vLog.Index,
+		Sender:      event.Sender.Hex(),
+		PriceSource: valuation.PriceSource,
+		USDValue:    usdValue,
+		Points:      points,
+		RuleVersion: SwapRuleVersion,
+	}
+	if valuation.Reserve0 != nil && valuation.Reserve1 != nil {
+		audit.Reserve0 = valuation.Reserve0.String()
+		audit.Reserve1 = valuation.Reserve1.String()
+	}
+	return audit
+}
+
+// ProcessSwapEvents values and records each swap in logs, returning the recorded events in
+// log order. With SwapWorkers > 1 senders are processed concurrently, but each sender's
+// swaps are still handled one at a time in log order so onboarding is decided exactly
+// as it would be sequentially. It returns an error if the batch or any swap in it could not
+// be processed and should be retried; malformed swap logs are skipped without an error.
+func ProcessSwapEvents(logs []types.Log) ([]*SwapEvent, error) {
 	swapEvents := make([]*SwapEvent, 0)
 
+	// The Chainlink price is only a fallback for blocks without historical reserves, so a stale
+	// or unavailable price still lets swaps be valued from reserves
 	ethPrice, err := GetEthereumPrice()
+	var staleErr *StalePriceError
+	if errors.As(err, &staleErr) {
+		LogError("Chainlink ETH price is stale, valuing swaps from pool reserves only: %v", err)
+	} else if err != nil {
+		LogError("Failed to fetch Ethereum price, valuing swaps from pool reserves only: %v", err)
+	}
+
+	decimals, err := GetPairDecimals(common.HexToAddress(UniswapV2PairAddress))
 	if err != nil {
-		LogError("Failed to fetch Ethereum price: %v", err)
-		return swapEvents
+		return swapEvents, LogErrorf(err, "failed to fetch pair token decimals")
 	}
 
-	for _, vLog := range logs {
-		var swapEvent SwapEvent
-		err := swapEventABI.UnpackIntoInterface(&swapEvent, "Swap", vLog.Data)
-		if err != nil {
-			LogError("Error unpacking swap event: %v", err)
+	// Pair metadata only labels the swaps, so failing to resolve it does not stop processing
+	var pair *PairMetadata
+	if metadata, err := GetPairMetadata(common.HexToAddress(UniswapV2PairAddress)); err != nil {
+		LogError("Failed to fetch pair metadata: %v", err)
+	} else {
+		pair = &metadata
+	}
+
+	campaign, err := GetCampaignConfig()
+	if err != nil {
+		return swapEvents, LogErrorf(err, "failed to fetch campaign config")
+	}
+
+	// Group log indexes by sender, keeping each sender's swaps in log order
+	var senders []common.Hash
+	bySender := make(map[common.Hash][]int)
+	for i, vLog := range logs {
+		if !campaign.ContainsBlock(vLog.BlockNumber) {
+			LogDebug("Skipping swap event %s in block %d outside campaign blocks %d-%d",
+				vLog.TxHash.Hex(), vLog.BlockNumber, campaign.StartBlock, campaign.EndBlock)
+			continue
+		}
+		if len(vLog.Topics) < 3 {
+			LogError("Skipping swap event %s with %d topics", vLog.TxHash.Hex(), len(vLog.Topics))
 			continue
 		}
+		sender := vLog.Topics[1]
+		if _, ok := bySender[sender]; !ok {
+			senders = append(senders, sender)
+		}
+		bySender[sender] = append(bySender[sender], i)
+	}
 
-		swapEvent.Sender = common.HexToAddress(vLog.Topics[1].Hex())
-		swapEvent.To = common.HexToAddress(vLog.Topics[2].Hex())
+	blockTimes := getBlockTimes(logs, bySender)
+
+	results := make([]*SwapEvent, len(logs))
+	errs := make([]error, len(logs))
+	processSender := func(sender common.Hash) {
+		for _, i := range bySender[sender] {
+			blockTime, ok := blockTimes[logs[i].BlockNumber]
+			if !ok {
+				// The header lookup failed and was logged; a later scan picks the swap up
+				errs[i] = fmt.Errorf("no header for block %d", logs[i].BlockNumber)
+				continue
+			}
+			results[i], errs[i] = processSwapLog(logs[i], ethPrice, decimals, pair, blockTime)
+		}
+	}
 
-		// Log the unpacked event data for debugging
-		LogInfo("Unpacked swap event: TX Hash: %s, Amount0In: %s, Amount1In: %s, Amount0Out: %s, Amount1Out: %s",
-			vLog.TxHash.Hex(), swapEvent.Amount0In, swapEvent.Amount1In, swapEvent.Amount0Out, swapEvent.Amount1Out)
+	workers := SwapWorkers
+	if workers > len(senders) {
+		workers = len(senders)
+	}
+	if workers <= 1 {
+		for _, sender := range senders {
+			processSender(sender)
+		}
+	} else {
+		queue := make(chan common.Hash)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for sender := range queue {
+					processSender(sender)
+				}
+			}()
+		}
+		for _, sender := range senders {
+			queue <- sender
+		}
+		close(queue)
+		wg.Wait()
+	}
 
-		usdValue, err := calculateUSDValueWithEthPrice(&swapEvent, ethPrice)
-		if err != nil {
-			LogError("Error calculating USD value for swap event %s: %v", vLog.TxHash.Hex(), err)
-			continue
+	var failed int
+	var firstErr error
+	for i, swapEvent := range results {
+		if swapEvent != nil {
+			swapEvents = append(swapEvents, swapEvent)
+		}
+		if errs[i] != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
 		}
+	}
+	if failed > 0 {
+		return swapEvents, fmt.Errorf("%d of %d swaps were not processed: %w", failed, len(logs), firstErr)
+	}
+	return swapEvents, nil
+}
 
-		swapEvent.USDValue = usdValue
+// getBlockTimes looks up when each block holding one of the grouped logs was mined, once per
+// block. Swaps are recorded at their block time, so a backfill of old blocks is bucketed into
+// the campaign week the swaps happened in.
+func getBlockTimes(logs []types.Log, bySender map[common.Hash][]int) map[uint64]time.Time {
+	blockTimes := make(map[uint64]time.Time)
+	failed := make(map[uint64]bool)
+	for _, indexes := range bySender {
+		for _, i := range indexes {
+			blockNumber := logs[i].BlockNumber
+			if _, ok := blockTimes[blockNumber]; ok || failed[blockNumber] {
+				continue
+			}
 
-		usdValueFloat64, _ := usdValue.Float64()
+			ctx, cancel := rpcContext()
+			header, err := Client.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+			cancel()
+			if err != nil {
+				LogError("Failed to fetch header for block %d, skipping its swaps: %v", blockNumber, err)
+				failed[blockNumber] = true
+				continue
+			}
+			blockTimes[blockNumber] = time.Unix(int64(header.Time), 0).UTC()
+		}
+	}
+	return blockTimes
+}
+
+// swapClass classifies a swap event before it is recorded
+type swapClass int
+
+const (
+	// swapInvalid moves no tokens into or out of the pool; it is skipped
+	swapInvalid swapClass = iota
+	// swapDust is a real swap worth less than a cent after rounding; it is recorded with 0 points
+	swapDust
+	// swapValued is a swap with a non-zero USD value
+	swapValued
+)
+
+// swapAmountsValid reports whether event moves tokens both into and out of the pool, as every
+// Uniswap V2 swap must
+func swapAmountsValid(event *SwapEvent) bool {
+	positive := func(amount *big.Int) bool {
+		return amount != nil && amount.Sign() > 0
+	}
+	return (positive(event.Amount0In) || positive(event.Amount1In)) &&
+		(positive(event.Amount0Out) || positive(event.Amount1Out))
+}
+
+// classifySwap classifies event given its USD value rounded to cents
+func classifySwap(event *SwapEvent, usdValue float64) swapClass {
+	switch {
+	case !swapAmountsValid(event):
+		return swapInvalid
+	case usdValue == 0:
+		return swapDust
+	default:
+		return swapValued
+	}
+}
+
+// recordSwapWrapper records a valued swap; tests replace it to observe processing order
+var recordSwapWrapper = func(address string, amountUSD float64, txHash string, blockNumber uint64, logIndex uint, timestamp time.Time) (int, bool, error) {
+	return RecordSwap(address, amountUSD, txHash, blockNumber, logIndex, timestamp)
+}
+
+// processSwapLog unpacks, values and records a single swap log mined at blockTime. It returns
+// nil if the swap was not recorded, with an error if it failed and should be retried.
+func processSwapLog(vLog types.Log, ethPrice *big.Float, decimals PairDecimals, pair *PairMetadata, blockTime time.Time) (*SwapEvent, error) {
+	swapEvent, err := unpackSwapLog(vLog)
+	if err != nil {
+		LogError("Error unpacking swap event: %v", err)
+		return nil, nil
+	}
+	swapEvent.Pair = pair
+
+	// Log the unpacked event data for debugging
+	LogInfo("Unpacked swap event: TX Hash: %s, Amount0In: %s, Amount1In: %s, Amount0Out: %s, Amount1Out: %s",
+		vLog.TxHash.Hex(), swapEvent.Amount0In, swapEvent.Amount1In, swapEvent.Amount0Out, swapEvent.Amount1Out)
+
+	if !swapAmountsValid(&swapEvent) {
+		LogError("Skipping invalid swap event %s: no tokens moved both into and out of the pool", vLog.TxHash.Hex())
+		return nil, nil
+	}
+
+	valuation, err := calculateSwapUSDValue(&swapEvent, vLog.BlockNumber, ethPrice, decimals)
+	if err != nil {
+		return nil, LogErrorf(err, "error calculating USD value for swap event %s", vLog.TxHash.Hex())
+	}
+
+	swapEvent.USDValue = valuation.USDValue
+
+	usdValueFloat64 := roundUSD(valuation.USDValue)
+	if classifySwap(&swapEvent, usdValueFloat64) == swapDust {
+		LogInfo("Swap event %s is worth less than $0.01, recording it with 0 points", vLog.TxHash.Hex())
+	}
+
+	points, recorded, err := recordSwapWrapper(swapEvent.Sender.Hex(), usdValueFloat64, vLog.TxHash.Hex(), vLog.BlockNumber, vLog.Index, blockTime)
+	if err != nil {
+		return nil, LogErrorf(err, "error recording swap event %s", vLog.TxHash.Hex())
+	}
 
-		err = RecordSwap(swapEvent.Sender.Hex(), usdValueFloat64, vLog.TxHash.Hex())
+	logSwapProcessed(vLog, &swapEvent, valuation, usdValueFloat64, points)
+	// Only newly stored swaps are audited, so re-processing a block range adds no audit rows
+	if recorded {
+		if err := RecordSwapAudit(newSwapAudit(vLog, &swapEvent, valuation, usdValueFloat64, points)); err != nil {
+			LogError("Error writing swap audit for %s: %v", vLog.TxHash.Hex(), err)
+		}
+	}
+
+	LogInfo("Processed swap event: TX Hash: %s, Sender: %s, To: %s, USD Value: %.2f",
+		vLog.TxHash.Hex(), swapEvent.Sender.Hex(), swapEvent.To.Hex(), usdValueFloat64)
+
+	return &swapEvent, nil
+}
+
+// unpackSwapLog decodes a Uniswap V3 Swap log, by its topic, or else a log of trackedSwapEvent
+// into a SwapEvent. V3 amounts are split by sign into the V2 in/out amounts.
+func unpackSwapLog(vLog types.Log) (SwapEvent, error) {
+	var swapEvent SwapEvent
+	if len(vLog.Topics) < 3 {
+		return swapEvent, fmt.Errorf("swap log %s has %d topics, expected 3", vLog.TxHash.Hex(), len(vLog.Topics))
+	}
+
+	if vLog.Topics[0] == crypto.Keccak256Hash(SwapEventV3Signature) {
+		var v3 swapEventV3
+		if err := swapEventV3ABI.UnpackIntoInterface(&v3, "Swap", vLog.Data); err != nil {
+			return swapEvent, err
+		}
+		swapEvent.Amount0In, swapEvent.Amount0Out = splitSignedAmount(v3.Amount0)
+		swapEvent.Amount1In, swapEvent.Amount1Out = splitSignedAmount(v3.Amount1)
+		swapEvent.SqrtPriceX96 = v3.SqrtPriceX96
+	} else {
+		// Amounts are read by position, so forks may name them differently
+		values, err := trackedSwapEvent.Inputs.Unpack(vLog.Data)
 		if err != nil {
-			LogError("Error recording swap event %s: %v", vLog.TxHash.Hex(), err)
-			continue
+			return swapEvent, err
 		}
+		swapEvent.Amount0In = values[0].(*big.Int)
+		swapEvent.Amount1In = values[1].(*big.Int)
+		swapEvent.Amount0Out = values[2].(*big.Int)
+		swapEvent.Amount1Out = values[3].(*big.Int)
+	}
 
-		swapEvents = append(swapEvents, &swapEvent)
+	// V2's to and V3's recipient are both the second indexed topic
+	swapEvent.Sender = common.HexToAddress(vLog.Topics[1].Hex())
+	swapEvent.To = common.HexToAddress(vLog.Topics[2].Hex())
+	return swapEvent, nil
+}
 
-		LogInfo("Processed swap event: TX Hash: %s, Sender: %s, To: %s, USD Value: %.2f",
-			vLog.TxHash.Hex(), swapEvent.Sender.Hex(), swapEvent.To.Hex(), usdValueFloat64)
+// splitSignedAmount splits a V3 pool delta into the amount paid in (positive) and out (negative)
+func splitSignedAmount(amount *big.Int) (in, out *big.Int) {
+	if amount.Sign() >= 0 {
+		return new(big.Int).Set(amount), big.NewInt(0)
 	}
+	return big.NewInt(0), new(big.Int).Neg(amount)
+}
 
-	return swapEvents
+// calculateUSDValueWithEthPrice values a swap at an external ETH/USD price instead of the pool price
+func calculateUSDValueWithEthPrice(event *SwapEvent, ethPrice *big.Float, decimals PairDecimals) (*big.Float, error) {
+	return usdValueAtPrice(event, ethPrice, decimals)
 }
 
-func calculateUSDValueWithEthPrice(event *SwapEvent, ethPrice *big.Float) (*big.Float, error) {
-	wethDecimals := new(big.Float).SetInt64(1e18)
-	usdcDecimals := new(big.Float).SetInt64(1e6)
+// USDDecimals is the number of decimal places USD amounts are stored and compared with
+const USDDecimals = 2
 
-	var usdValue *big.Float
+// roundUSD converts a USD value to a float64 rounded to USDDecimals places using round-half-even.
+// All USD amounts are rounded here before being stored or used for points, so results are
+// deterministic regardless of how the big.Float was computed.
+func roundUSD(value *big.Float) float64 {
+	if value == nil {
+		return 0
+	}
+	if value.IsInf() {
+		f, _ := value.Float64()
+		return f
+	}
 
-	if event.Amount0In.Cmp(big.NewInt(0)) > 0 {
-		// WETH was input
-		wethAmount := new(big.Float).Quo(new(big.Float).SetInt(event.Amount0In), wethDecimals)
-		usdValue = new(big.Float).Mul(wethAmount, ethPrice)
-	} else if event.Amount1Out.Cmp(big.NewInt(0)) > 0 {
-		// USDC was output
-		usdValue = new(big.Float).Quo(new(big.Float).SetInt(event.Amount1Out), usdcDecimals)
-	} else if event.Amount1In.Cmp(big.NewInt(0)) > 0 {
-		// USDC was input
-		usdValue = new(big.Float).Quo(new(big.Float).SetInt(event.Amount1In), usdcDecimals)
-	} else if event.Amount0Out.Cmp(big.NewInt(0)) > 0 {
-		// WETH was output
-		wethAmount := new(big.Float).Quo(new(big.Float).SetInt(event.Amount0Out), wethDecimals)
-		usdValue = new(big.Float).Mul(wethAmount, ethPrice)
-	} else {
-		return nil, fmt.Errorf("invalid swap event: no input or output")
+	rounded, _ := roundUSDRat(value).Float64()
+	return rounded
+}
+
+// formatUSD formats a finite USD value as a decimal string with exactly USDDecimals places,
+// rounded like roundUSD. Unlike a float64 it stays exact for values of any size.
+func formatUSD(value *big.Float) string {
+	return roundUSDRat(value).FloatString(USDDecimals)
+}
+
+// roundUSDRat rounds a finite value to USDDecimals places using round-half-even
+func roundUSDRat(value *big.Float) *big.Rat {
+	rat, _ := value.Rat(nil)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(USDDecimals), nil)
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt(scale))
+
+	// Split into integer part and remainder, truncating toward zero
+	quotient, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+
+	// Compare twice the remainder against the denominator to decide rounding
+	twiceRemainder := new(big.Int).Abs(remainder)
+	twiceRemainder.Lsh(twiceRemainder, 1)
+	cmp := twiceRemainder.Cmp(scaled.Denom())
+	if cmp > 0 || (cmp == 0 && quotient.Bit(0) == 1) {
+		if remainder.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
 	}
 
-	return usdValue, nil
+	return new(big.Rat).SetFrac(quotient, scale)
 }
 
 func CalculateSwapVolume(event *SwapEvent) *big.Int {
@@ -309,12 +1091,12 @@ func CalculateSwapVolume(event *SwapEvent) *big.Int {
 }
 
 func GetLatestBlockNumber() (uint64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := rpcContext()
 	defer cancel()
 
 	header, err := Client.HeaderByNumber(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get latest block number: %w", err)
+		return 0, fmt.Errorf("failed to get latest block number: %w", &EthereumError{Operation: "HeaderByNumber", Err: err})
 	}
 
 	LogInfo("Retrieved latest block number: %d", header.Number.Uint64())
@@ -322,15 +1104,21 @@ func GetLatestBlockNumber() (uint64, error) {
 }
 
 func getPoolReserves(blockNumber uint64) (*big.Int, *big.Int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := rpcContext()
 	defer cancel()
 
 	contractAddress := common.HexToAddress(UniswapV2PairAddress)
 
+	// A nil block queries the latest state, for endpoints without archive data
+	var block *big.Int
+	if !ReservesAtLatestBlock {
+		block = new(big.Int).SetUint64(blockNumber)
+	}
+
 	// Check if the contract exists at the given block number
-	code, err := Client.CodeAt(ctx, contractAddress, big.NewInt(int64(blockNumber)))
+	code, err := Client.CodeAt(ctx, contractAddress, block)
 	if err != nil {
-		return nil, nil, LogErrorf(err, "failed to check contract code")
+		return nil, nil, LogErrorf(&EthereumError{Operation: "CodeAt", Err: err}, "failed to check contract code")
 	}
 	if len(code) == 0 {
 		return nil, nil, LogErrorf(nil, "no contract found at the specified address for block %d", blockNumber)
@@ -354,9 +1142,9 @@ func getPoolReserves(blockNumber uint64) (*big.Int, *big.Int, error) {
 		Data: data,
 	}
 
-	result, err := Client.CallContract(ctx, msg, big.NewInt(int64(blockNumber)))
+	result, err := Client.CallContract(ctx, msg, block)
 	if err != nil {
-		return nil, nil, LogErrorf(err, "failed to call getReserves")
+		return nil, nil, LogErrorf(&EthereumError{Operation: "getReserves", Err: err}, "failed to call getReserves")
 	}
 
 	// Unpack the result
@@ -384,3 +1172,17 @@ func getPoolReserves(blockNumber uint64) (*big.Int, *big.Int, error) {
 
 	return reserve0, reserve1, nil
 }
+
+// normalizeAddress lowercases an address for use as a storage key or lookup value
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// checksumAddress formats an address with EIP-55 mixed-case checksum for display,
+// returning the input unchanged if it is not a valid hex address
+func checksumAddress(address string) string {
+	if !common.IsHexAddress(address) {
+		return address
+	}
+	return common.HexToAddress(address).Hex()
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -80,11 +87,148 @@ func TestInitEthereumClient(t *testing.T) {
 		return mockClient, nil
 	}
 
+	originalURLs := RPCURLs
+	defer func() { RPCURLs = originalURLs }()
+	RPCURLs = []string{"http://localhost:8545"}
+
 	err := InitEthereumClient(mockClientCreator)
 	assert.NoError(t, err)
 	assert.Equal(t, mockClient, Client)
 }
 
+func TestInitEthereumClientWithoutRPCURL(t *testing.T) {
+	originalURL, originalURLs, originalErr := RPCURL, RPCURLs, rpcConfigErr
+	defer func() { RPCURL, RPCURLs, rpcConfigErr = originalURL, originalURLs, originalErr }()
+	RPCURL, rpcConfigErr = resolveRPCURL("", "")
+	RPCURLs = parseRPCURLs("", RPCURL)
+
+	// The missing configuration is an error from InitEthereumClient rather than a fatal at startup
+	err := InitEthereumClient(func(url string) (EthereumClient, error) {
+		t.Fatalf("unexpected connection to %q", url)
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, rpcConfigErr)
+}
+
+func TestWarnIgnoredRPCURL(t *testing.T) {
+	var buf bytes.Buffer
+	originalOutput := errorLogger.Writer()
+	errorLogger.SetOutput(&buf)
+	defer errorLogger.SetOutput(originalOutput)
+
+	// RPC_URLS unset or listing ETH_RPC_URL
+	warnIgnoredRPCURL("http://localhost:8545", parseRPCURLs("", "http://localhost:8545"))
+	warnIgnoredRPCURL("http://localhost:8545", parseRPCURLs("https://a.example.com, http://localhost:8545", "http://localhost:8545"))
+	warnIgnoredRPCURL("", parseRPCURLs("https://a.example.com", ""))
+	assert.Empty(t, buf.String())
+
+	warnIgnoredRPCURL("http://localhost:8545", parseRPCURLs("https://a.example.com,https://b.example.com", "http://localhost:8545"))
+	assert.Contains(t, buf.String(), "using RPC_URLS only")
+	assert.NotContains(t, buf.String(), "localhost")
+}
+
+func TestOfflineClient(t *testing.T) {
+	originalOffline, originalBreaker, originalTokens := EthOffline, ethPriceBreaker, tokenMetadata
+	defer func() { EthOffline, ethPriceBreaker, tokenMetadata = originalOffline, originalBreaker, originalTokens }()
+	EthOffline = true
+	ethPriceBreaker = newPriceBreaker(defaultPriceBreakerThreshold, defaultPriceBreakerCooldown)
+	tokenMetadata = newTokenCache()
+
+	assert.NoError(t, InitEthereumClient(func(url string) (EthereumClient, error) {
+		t.Fatalf("unexpected connection to %q", url)
+		return nil, nil
+	}))
+	assert.Equal(t, offlineClient{}, Client)
+
+	price, err := GetEthereumPrice()
+	assert.NoError(t, err)
+	actual, _ := price.Float64()
+	assert.Equal(t, 2000.0, actual)
+
+	pair, err := GetPairMetadata(common.HexToAddress(UniswapV2PairAddress))
+	assert.NoError(t, err)
+	assert.Equal(t, "USDC/WETH", pair.Label)
+
+	decimals, err := GetPairDecimals(common.HexToAddress(UniswapV2PairAddress))
+	assert.NoError(t, err)
+	assert.Equal(t, PairDecimals{Token0: 6, Token1: 18, WETHIndex: 1}, decimals)
+
+	reserve0, reserve1, err := getPoolReserves(100)
+	assert.NoError(t, err)
+	assert.Equal(t, offlineReserve0, reserve0)
+	assert.Equal(t, offlineReserve1, reserve1)
+
+	blockNumber, err := Client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, offlineBlockNumber, blockNumber)
+
+	logs, err := FetchSwapEvents(big.NewInt(1), big.NewInt(2))
+	assert.NoError(t, err)
+	assert.Empty(t, logs)
+
+	_, err = Client.SuggestGasPrice(context.Background())
+	assert.ErrorIs(t, err, errOffline)
+}
+
+// closableClient counts Close calls on top of MockEthereumClient
+type closableClient struct {
+	MockEthereumClient
+	closed int
+}
+
+func (c *closableClient) Close() {
+	c.closed++
+}
+
+func TestCloseEthereumClient(t *testing.T) {
+	primary := new(closableClient)
+	secondary := new(closableClient)
+	Client = NewFailoverClient([]string{"http://primary", "http://secondary"}, []EthereumClient{primary, secondary})
+
+	CloseEthereumClient()
+	assert.Nil(t, Client)
+	assert.Equal(t, 1, primary.closed)
+	assert.Equal(t, 1, secondary.closed)
+
+	// A second close is a no-op
+	CloseEthereumClient()
+	assert.Equal(t, 1, primary.closed)
+	assert.Equal(t, 1, secondary.closed)
+}
+
+func TestClassifySwap(t *testing.T) {
+	zero := big.NewInt(0)
+
+	allZero := &SwapEvent{Amount0In: zero, Amount1In: zero, Amount0Out: zero, Amount1Out: zero}
+	assert.Equal(t, swapInvalid, classifySwap(allZero, 0))
+
+	inOnly := &SwapEvent{Amount0In: big.NewInt(1e18), Amount1In: zero, Amount0Out: zero, Amount1Out: zero}
+	assert.Equal(t, swapInvalid, classifySwap(inOnly, 2000))
+
+	// 1 wei of WETH for 1 micro-USDC is a real swap that rounds to $0.00
+	dust := &SwapEvent{Amount0In: big.NewInt(1), Amount1In: zero, Amount0Out: zero, Amount1Out: big.NewInt(1)}
+	usdValue, err := calculateUSDValue(dust, new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18)), big.NewInt(200000e6), PairDecimals{Token0: 18, Token1: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, roundUSD(usdValue))
+	assert.Equal(t, swapDust, classifySwap(dust, roundUSD(usdValue)))
+
+	valued := &SwapEvent{Amount0In: big.NewInt(1e18), Amount1In: zero, Amount0Out: zero, Amount1Out: big.NewInt(2000e6)}
+	assert.Equal(t, swapValued, classifySwap(valued, 2000))
+}
+
+func TestResolveRPCURL(t *testing.T) {
+	url, err := resolveRPCURL("https://eth-mainnet.g.alchemy.com/v2/key", "project")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://eth-mainnet.g.alchemy.com/v2/key", url, "ETH_RPC_URL should take precedence")
+
+	url, err = resolveRPCURL("", "project")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mainnet.infura.io/v3/project", url)
+
+	_, err = resolveRPCURL("", "")
+	assert.Error(t, err)
+}
+
 func TestCalculateUSDValue(t *testing.T) {
 	swapEvent := &SwapEvent{
 		Amount0In:  big.NewInt(1e18), // 1 WETH
@@ -97,7 +241,7 @@ func TestCalculateUSDValue(t *testing.T) {
 	reserve0 := big.NewInt(100).Mul(big.NewInt(100), big.NewInt(1000000000000000000)) // 100 WETH
 	reserve1 := big.NewInt(200000e6)                                                  // 200,000 USDC (assuming 6 decimals)
 
-	usdValue, err := calculateUSDValue(swapEvent, reserve0, reserve1)
+	usdValue, err := calculateUSDValue(swapEvent, reserve0, reserve1, PairDecimals{Token0: 18, Token1: 6})
 	assert.NoError(t, err)
 
 	// Print intermediate values for debugging
@@ -115,6 +259,98 @@ func TestCalculateUSDValue(t *testing.T) {
 	assert.True(t, absDiff.Cmp(tolerance) < 0, "USD value %v is not close enough to expected %v", usdValue, expected)
 }
 
+func TestCalculateUSDValueBothInputs(t *testing.T) {
+	reserve0 := new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18)) // 100 WETH
+	reserve1 := big.NewInt(200000e6)                                // 200,000 USDC, so 2000 USDC per WETH
+	decimals := PairDecimals{Token0: 18, Token1: 6}
+
+	tests := []struct {
+		name      string
+		amount0In *big.Int
+		amount1In *big.Int
+		expected  float64
+	}{
+		{"WETH leg dominates", big.NewInt(1e18), big.NewInt(50e6), 2000},
+		{"USDC leg dominates", big.NewInt(1e16), big.NewInt(3000e6), 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &SwapEvent{
+				Amount0In:  tt.amount0In,
+				Amount1In:  tt.amount1In,
+				Amount0Out: big.NewInt(0),
+				Amount1Out: big.NewInt(1000e6),
+			}
+
+			usdValue, err := calculateUSDValue(event, reserve0, reserve1, decimals)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, roundUSD(usdValue))
+
+			usdValue, err = calculateUSDValueWithEthPrice(event, big.NewFloat(2000), decimals)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, roundUSD(usdValue))
+		})
+	}
+}
+
+func TestCalculateUSDValueWETHAsToken1(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	tokenMetadata = newTokenCache()
+
+	// The mainnet pair orders its tokens by address: token0 is USDC and token1 WETH
+	pair := common.HexToAddress(defaultPairAddress)
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	mockPairDecimals(mockClient, pair, usdc, weth, 6, 18)
+
+	decimals, err := GetPairDecimals(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, PairDecimals{Token0: 6, Token1: 18, WETHIndex: 1}, decimals)
+
+	reserve0 := big.NewInt(200000e6)                                // 200,000 USDC
+	reserve1 := new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18)) // 100 WETH, so 2000 USDC per WETH
+	sqrtPriceX96, _ := new(big.Int).SetString("1771595571142957102961017161607260", 10)
+
+	tests := []struct {
+		name     string
+		event    *SwapEvent
+		expected float64
+	}{
+		{"WETH in", &SwapEvent{Amount0In: big.NewInt(0), Amount1In: big.NewInt(1e18), Amount0Out: big.NewInt(0), Amount1Out: big.NewInt(0)}, 2000},
+		{"WETH out", &SwapEvent{Amount0In: big.NewInt(0), Amount1In: big.NewInt(0), Amount0Out: big.NewInt(0), Amount1Out: big.NewInt(5e17)}, 1000},
+		{"USDC in", &SwapEvent{Amount0In: big.NewInt(1500e6), Amount1In: big.NewInt(0), Amount0Out: big.NewInt(0), Amount1Out: big.NewInt(7e17)}, 1500},
+		{"USDC out", &SwapEvent{Amount0In: big.NewInt(0), Amount1In: big.NewInt(0), Amount0Out: big.NewInt(2500e6), Amount1Out: big.NewInt(0)}, 2500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usdValue, err := calculateUSDValue(tt.event, reserve0, reserve1, decimals)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, roundUSD(usdValue))
+
+			usdValue, err = calculateUSDValueFromSqrtPrice(tt.event, sqrtPriceX96, decimals)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, roundUSD(usdValue))
+		})
+	}
+}
+
+func TestGetPairDecimalsRequiresWETHLeg(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	tokenMetadata = newTokenCache()
+
+	pair := common.HexToAddress(UniswapV2PairAddress)
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	usdt := common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7")
+	mockPairDecimals(mockClient, pair, usdc, usdt, 6, 6)
+
+	_, err := GetPairDecimals(pair)
+	assert.ErrorContains(t, err, "no single WETH leg")
+}
+
 func TestGetPoolReserves(t *testing.T) {
 	mockClient := new(MockEthereumClient)
 	Client = mockClient
@@ -163,6 +399,29 @@ func TestGetLatestBlockNumber(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
+func TestFetchSwapEventsUsesConfiguredPair(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	t.Setenv("PAIR_ADDRESS", "0x397ff1542f962076d0bfe58ea045ffa2d347aca0")
+	originalPair := UniswapV2PairAddress
+	defer func() { UniswapV2PairAddress = originalPair }()
+	UniswapV2PairAddress = envAddress("PAIR_ADDRESS", defaultPairAddress)
+
+	configured := common.HexToAddress("0x397ff1542f962076d0bfe58ea045ffa2d347aca0")
+	mockClient.On("FilterLogs", mock.Anything, mock.MatchedBy(func(q ethereum.FilterQuery) bool {
+		return len(q.Addresses) == 1 && q.Addresses[0] == configured
+	})).Return([]types.Log{}, nil).Once()
+
+	_, err := FetchSwapEvents(big.NewInt(1), big.NewInt(2))
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+
+	// An invalid address falls back to the default pair
+	t.Setenv("PAIR_ADDRESS", "not-an-address")
+	assert.Equal(t, defaultPairAddress, envAddress("PAIR_ADDRESS", defaultPairAddress))
+}
+
 func TestFetchSwapEvents(t *testing.T) {
 	mockClient := new(MockEthereumClient)
 	Client = mockClient
@@ -187,3 +446,969 @@ func TestFetchSwapEvents(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestFailoverClientFallsBackToSecondary(t *testing.T) {
+	primary := new(MockEthereumClient)
+	secondary := new(MockEthereumClient)
+
+	primary.On("BlockNumber", mock.Anything).Return(uint64(0), rpc.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"})
+	secondary.On("BlockNumber", mock.Anything).Return(uint64(12345), nil)
+
+	originalURLs := RPCURLs
+	defer func() { RPCURLs = originalURLs }()
+	RPCURLs = []string{"http://primary", "http://secondary"}
+
+	clients := map[string]EthereumClient{"http://primary": primary, "http://secondary": secondary}
+	err := InitEthereumClient(func(url string) (EthereumClient, error) {
+		return clients[url], nil
+	})
+	assert.NoError(t, err)
+
+	blockNumber, err := Client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345), blockNumber)
+
+	// The primary is now unhealthy, so the next call goes straight to the secondary
+	blockNumber, err = Client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345), blockNumber)
+
+	primary.AssertNumberOfCalls(t, "BlockNumber", 1)
+	secondary.AssertNumberOfCalls(t, "BlockNumber", 2)
+}
+
+func TestFailoverClientDoesNotRetryRequestErrors(t *testing.T) {
+	primary := new(MockEthereumClient)
+	secondary := new(MockEthereumClient)
+
+	primary.On("BlockNumber", mock.Anything).Return(uint64(0), fmt.Errorf("execution reverted"))
+
+	client := NewFailoverClient([]string{"http://primary", "http://secondary"}, []EthereumClient{primary, secondary})
+
+	_, err := client.BlockNumber(context.Background())
+	assert.EqualError(t, err, "execution reverted")
+	secondary.AssertNotCalled(t, "BlockNumber", mock.Anything)
+}
+
+func TestFailoverClientStopsOnCallerDeadline(t *testing.T) {
+	primary := new(MockEthereumClient)
+	secondary := new(MockEthereumClient)
+
+	primary.On("BlockNumber", mock.Anything).Return(uint64(0), context.DeadlineExceeded).Once()
+	primary.On("BlockNumber", mock.Anything).Return(uint64(12345), nil).Once()
+
+	client := NewFailoverClient([]string{"http://primary", "http://secondary"}, []EthereumClient{primary, secondary})
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err := client.BlockNumber(expired)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	secondary.AssertNotCalled(t, "BlockNumber", mock.Anything)
+
+	// The primary was not blamed for the caller's deadline and still serves the next call
+	blockNumber, err := client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345), blockNumber)
+}
+
+func TestFailoverClientSplitsDeadlineBetweenEndpoints(t *testing.T) {
+	primary := new(MockEthereumClient)
+	secondary := new(MockEthereumClient)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	parentDeadline, _ := ctx.Deadline()
+
+	// The slow primary only gets its share of the deadline, leaving time for the secondary
+	primary.On("BlockNumber", mock.Anything).Run(func(args mock.Arguments) {
+		deadline, ok := args.Get(0).(context.Context).Deadline()
+		assert.True(t, ok)
+		assert.True(t, deadline.Before(parentDeadline.Add(-20*time.Second)))
+	}).Return(uint64(0), context.DeadlineExceeded)
+	secondary.On("BlockNumber", mock.Anything).Return(uint64(12345), nil)
+
+	client := NewFailoverClient([]string{"http://primary", "http://secondary"}, []EthereumClient{primary, secondary})
+
+	blockNumber, err := client.BlockNumber(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345), blockNumber)
+}
+
+func TestBackfillSwapEvents(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	firstChunk := mock.MatchedBy(func(q ethereum.FilterQuery) bool {
+		return q.FromBlock.Uint64() == 100 && q.ToBlock.Uint64() == 109
+	})
+	secondChunk := mock.MatchedBy(func(q ethereum.FilterQuery) bool {
+		return q.FromBlock.Uint64() == 110 && q.ToBlock.Uint64() == 115
+	})
+	mockClient.On("FilterLogs", mock.Anything, firstChunk).Return([]types.Log{}, nil).Once()
+	mockClient.On("FilterLogs", mock.Anything, secondChunk).Return([]types.Log{}, nil).Once()
+
+	result, err := BackfillSwapEvents(100, 115, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Chunks)
+	assert.Equal(t, 0, result.EventsFetched)
+	mockClient.AssertExpectations(t)
+}
+
+func TestBackfillSwapEventsStoresBlockTimes(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	SetDB(db)
+
+	// The backfill runs in week 3 of the campaign, replaying swaps from weeks 1 and 2
+	campaignStart := time.Now().Add(-15 * 24 * time.Hour).Truncate(time.Second)
+	campaignEnd := campaignStart.Add(4 * CampaignWeek)
+	swaps := []struct {
+		block     uint64
+		txHash    common.Hash
+		blockTime time.Time
+	}{
+		{105, common.HexToHash("0x01"), campaignStart.Add(time.Hour).UTC()},
+		{112, common.HexToHash("0x02"), campaignStart.Add(CampaignWeek + time.Hour).UTC()},
+	}
+
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	for i, swap := range swaps {
+		block := swap.block
+		mockClient.On("HeaderByNumber", mock.Anything, new(big.Int).SetUint64(block)).
+			Return(&types.Header{Number: new(big.Int).SetUint64(block), Time: uint64(swap.blockTime.Unix())}, nil).Once()
+		mockClient.On("FilterLogs", mock.Anything, mock.MatchedBy(func(q ethereum.FilterQuery) bool {
+			return q.FromBlock.Uint64() == uint64(100+10*i)
+		})).Return([]types.Log{newSwapLog(sender, swap.txHash, block, big.NewInt(1e16), big.NewInt(20e6))}, nil).Once()
+	}
+	mockSwapPricing(t, mockClient)
+
+	for _, swap := range swaps {
+		dbMock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(campaignStart, campaignEnd, true, false))
+		dbMock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(campaignStart, campaignEnd, true, false))
+		dbMock.ExpectQuery("INSERT INTO users").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		dbMock.ExpectBegin()
+		dbMock.ExpectExec("INSERT INTO swap_events").
+			WithArgs(1, swap.txHash.Hex(), 20.0, swap.blockTime, swap.block, uint(0)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		dbMock.ExpectCommit()
+		dbMock.ExpectExec("INSERT INTO audit_swap_processing").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	result, err := BackfillSwapEvents(100, 115, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Chunks)
+	assert.Equal(t, 2, result.EventsProcessed)
+	mockClient.AssertExpectations(t)
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestProcessSwapEventsAuditsOnlyNewSwaps(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	SetDB(db)
+
+	campaignStart := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	campaignEnd := campaignStart.Add(4 * CampaignWeek)
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	newSwap := newSwapLog(sender, common.HexToHash("0x01"), 105, big.NewInt(1e16), big.NewInt(20e6))
+	duplicate := newSwapLog(sender, common.HexToHash("0x02"), 106, big.NewInt(1e16), big.NewInt(20e6))
+	beforeCampaign := newSwapLog(sender, common.HexToHash("0x03"), 107, big.NewInt(1e16), big.NewInt(20e6))
+
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	blockTimes := map[uint64]time.Time{
+		105: campaignStart.Add(time.Hour),
+		106: campaignStart.Add(2 * time.Hour),
+		107: campaignStart.Add(-time.Hour),
+	}
+	for block, blockTime := range blockTimes {
+		mockClient.On("HeaderByNumber", mock.Anything, new(big.Int).SetUint64(block)).
+			Return(&types.Header{Number: new(big.Int).SetUint64(block), Time: uint64(blockTime.Unix())}, nil)
+	}
+	mockSwapPricing(t, mockClient)
+
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(campaignStart, campaignEnd, true, false))
+
+	// Only the newly inserted swap is audited
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(campaignStart, campaignEnd, true, false))
+	dbMock.ExpectQuery("INSERT INTO users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec("INSERT INTO swap_events").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectCommit()
+	dbMock.ExpectExec("INSERT INTO audit_swap_processing").
+		WithArgs(newSwap.TxHash.Hex(), uint64(105), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// The duplicate is already stored, so nothing is inserted and no audit row is written
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(campaignStart, campaignEnd, true, false))
+	dbMock.ExpectQuery("INSERT INTO users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec("INSERT INTO swap_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+
+	// The swap before the campaign started is ignored without an audit row
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(campaignStart, campaignEnd, true, false))
+
+	// An unexpected audit insert fails against the mock and is logged
+	var buf bytes.Buffer
+	originalOutput := errorLogger.Writer()
+	errorLogger.SetOutput(&buf)
+	defer errorLogger.SetOutput(originalOutput)
+
+	_, err = ProcessSwapEvents([]types.Log{newSwap, duplicate, beforeCampaign})
+	assert.NoError(t, err)
+
+	assert.NotContains(t, buf.String(), "swap audit")
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBackfillSwapEventsStopsOnProcessingFailure(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	SetDB(db)
+
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	mockClient.On("FilterLogs", mock.Anything, mock.Anything).
+		Return([]types.Log{newSwapLog(sender, common.HexToHash("0x01"), 105, big.NewInt(1e16), big.NewInt(20e6))}, nil).Once()
+	mockSwapPricing(t, mockClient)
+
+	dbMock.ExpectQuery(campaignConfigQuery).WillReturnError(errors.New("connection refused"))
+
+	// The failed chunk is not counted, so the caller resumes from its first block
+	result, err := BackfillSwapEvents(100, 115, 10)
+	assert.Error(t, err)
+	assert.Equal(t, 0, result.Chunks)
+	assert.Equal(t, 1, result.EventsFetched)
+	mockClient.AssertNumberOfCalls(t, "FilterLogs", 1)
+}
+
+func TestBackfillSwapEventsInvalidRange(t *testing.T) {
+	_, err := BackfillSwapEvents(200, 100, 10)
+	assert.Error(t, err)
+}
+
+// mockPairDecimals sets up CallContract expectations for resolving a pair's token decimals
+func mockPairDecimals(mockClient *MockEthereumClient, pair, token0, token1 common.Address, decimals0, decimals1 uint8) {
+	callTo := func(to common.Address, method string) interface{} {
+		selector := parsedERC20ABI.Methods[method].ID
+		return mock.MatchedBy(func(call ethereum.CallMsg) bool {
+			return call.To != nil && *call.To == to && bytes.Equal(call.Data[:4], selector)
+		})
+	}
+
+	mockClient.On("CallContract", mock.Anything, callTo(pair, "token0"), mock.Anything).Return(common.LeftPadBytes(token0.Bytes(), 32), nil)
+	mockClient.On("CallContract", mock.Anything, callTo(pair, "token1"), mock.Anything).Return(common.LeftPadBytes(token1.Bytes(), 32), nil)
+	mockClient.On("CallContract", mock.Anything, callTo(token0, "decimals"), mock.Anything).Return(common.LeftPadBytes([]byte{decimals0}, 32), nil)
+	mockClient.On("CallContract", mock.Anything, callTo(token1, "decimals"), mock.Anything).Return(common.LeftPadBytes([]byte{decimals1}, 32), nil)
+}
+
+// mockTokenSymbols sets up CallContract expectations for each token's symbol()
+func mockTokenSymbols(mockClient *MockEthereumClient, symbols map[common.Address]string) {
+	selector := parsedERC20ABI.Methods["symbol"].ID
+	for token, symbol := range symbols {
+		token := token
+		encoded, _ := parsedERC20ABI.Methods["symbol"].Outputs.Pack(symbol)
+		mockClient.On("CallContract", mock.Anything, mock.MatchedBy(func(call ethereum.CallMsg) bool {
+			return call.To != nil && *call.To == token && bytes.Equal(call.Data[:4], selector)
+		}), mock.Anything).Return(encoded, nil)
+	}
+}
+
+func TestGetPairMetadata(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	tokenMetadata = newTokenCache()
+
+	pair := common.HexToAddress(UniswapV2PairAddress)
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	mockPairDecimals(mockClient, pair, weth, usdc, 18, 6)
+	mockTokenSymbols(mockClient, map[common.Address]string{weth: "WETH", usdc: "USDC"})
+
+	expected := PairMetadata{
+		Address: pair.Hex(),
+		Label:   "WETH/USDC",
+		Token0:  TokenInfo{Address: weth.Hex(), Symbol: "WETH"},
+		Token1:  TokenInfo{Address: usdc.Hex(), Symbol: "USDC"},
+	}
+
+	metadata, err := GetPairMetadata(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, metadata)
+
+	// Tokens and symbols are cached after the first lookup
+	metadata, err = GetPairMetadata(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, metadata)
+	mockClient.AssertNumberOfCalls(t, "CallContract", 4)
+}
+
+func TestGetPairDecimals(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	tokenMetadata = newTokenCache()
+
+	pair := common.HexToAddress(UniswapV2PairAddress)
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	mockPairDecimals(mockClient, pair, weth, usdc, 18, 6)
+
+	decimals, err := GetPairDecimals(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, PairDecimals{Token0: 18, Token1: 6}, decimals)
+
+	// A second lookup is served from the cache
+	decimals, err = GetPairDecimals(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, PairDecimals{Token0: 18, Token1: 6}, decimals)
+
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "CallContract", 4)
+}
+
+func TestValidateTrackedPair(t *testing.T) {
+	originalSymbols := stablecoinSymbols
+	defer func() { stablecoinSymbols = originalSymbols }()
+
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	usdt := common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7")
+	pepe := common.HexToAddress("0x6982508145454Ce325dDbE47a25d4ec3d2311933")
+	symbols := map[common.Address]string{weth: "WETH", usdc: "USDC", usdt: "USDT", pepe: "PEPE"}
+
+	tests := []struct {
+		name           string
+		stablecoins    string
+		token0, token1 common.Address
+		decimals0      uint8
+		decimals1      uint8
+		expectedErr    string
+	}{
+		{"USDC/WETH", "", usdc, weth, 6, 18, ""},
+		{"WETH/USDT", "", weth, usdt, 18, 6, ""},
+		// Without STABLECOIN_SYMBOLS any token is accepted next to WETH
+		{"PEPE/WETH", "", pepe, weth, 18, 18, ""},
+		{"no WETH leg", "", usdc, usdt, 6, 6, "no single WETH leg"},
+		{"listed stablecoin", "usdc, USDT", weth, usdt, 18, 6, ""},
+		{"unlisted stablecoin", "USDC,USDT", pepe, weth, 18, 18, "no accepted stablecoin leg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stablecoinSymbols = parseSymbols(tt.stablecoins)
+			mockClient := new(MockEthereumClient)
+			Client = mockClient
+			tokenMetadata = newTokenCache()
+			mockPairDecimals(mockClient, common.HexToAddress(UniswapV2PairAddress), tt.token0, tt.token1, tt.decimals0, tt.decimals1)
+			mockTokenSymbols(mockClient, symbols)
+
+			err := ValidateTrackedPair()
+			if tt.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				assert.False(t, isFailoverError(err), "an invalid pair is not an RPC failure")
+			}
+		})
+	}
+
+	// A node that cannot be reached is reported as an RPC failure rather than an invalid pair
+	stablecoinSymbols = nil
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	tokenMetadata = newTokenCache()
+	mockClient.On("CallContract", mock.Anything, mock.Anything, mock.Anything).Return([]byte(nil), context.DeadlineExceeded)
+	err := ValidateTrackedPair()
+	assert.Error(t, err)
+	assert.True(t, isFailoverError(err))
+
+	// The offline client serves a valid pair
+	Client = offlineClient{}
+	tokenMetadata = newTokenCache()
+	assert.NoError(t, ValidateTrackedPair())
+}
+
+func TestCalculateSwapUSDValueFallsBackWithoutArchiveData(t *testing.T) {
+	originalGetPoolReserves := getPoolReservesWrapper
+	defer func() { getPoolReservesWrapper = originalGetPoolReserves }()
+	getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error) {
+		return nil, nil, fmt.Errorf("failed to call getReserves: missing trie node 1a2b3c (path )")
+	}
+
+	swapEvent := &SwapEvent{
+		Amount0In:  big.NewInt(2e18), // 2 WETH
+		Amount1In:  big.NewInt(0),
+		Amount0Out: big.NewInt(0),
+		Amount1Out: big.NewInt(0),
+	}
+
+	valuation, err := calculateSwapUSDValue(swapEvent, 12345, big.NewFloat(1500), PairDecimals{Token0: 18, Token1: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, "chainlink", valuation.PriceSource)
+
+	value, _ := valuation.USDValue.Float64()
+	assert.InDelta(t, 3000.0, value, 1e-6)
+}
+
+func TestCalculateSwapUSDValueReturnsOtherReserveErrors(t *testing.T) {
+	originalGetPoolReserves := getPoolReservesWrapper
+	defer func() { getPoolReservesWrapper = originalGetPoolReserves }()
+	getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error) {
+		return nil, nil, fmt.Errorf("failed to call getReserves: execution reverted")
+	}
+
+	swapEvent := &SwapEvent{
+		Amount0In:  big.NewInt(2e18),
+		Amount1In:  big.NewInt(0),
+		Amount0Out: big.NewInt(0),
+		Amount1Out: big.NewInt(0),
+	}
+
+	_, err := calculateSwapUSDValue(swapEvent, 12345, big.NewFloat(1500), PairDecimals{Token0: 18, Token1: 6})
+	assert.Error(t, err)
+}
+
+func TestGetPoolReservesAtLatestBlock(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	ReservesAtLatestBlock = true
+	defer func() { ReservesAtLatestBlock = false }()
+
+	mockClient.On("CodeAt", mock.Anything, mock.Anything, (*big.Int)(nil)).Return([]byte{1}, nil)
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(
+		append(
+			append(
+				common.LeftPadBytes(big.NewInt(1000000).Bytes(), 32),
+				common.LeftPadBytes(big.NewInt(2000000).Bytes(), 32)...,
+			),
+			common.LeftPadBytes(big.NewInt(int64(time.Now().Unix())).Bytes(), 32)...,
+		),
+		nil,
+	)
+
+	_, _, err := getPoolReserves(12345)
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestLogSwapProcessed(t *testing.T) {
+	var buf bytes.Buffer
+	originalOutput := debugLogger.Writer()
+	debugLogger.SetOutput(&buf)
+	SetLogLevel(LevelDebug)
+	defer func() {
+		debugLogger.SetOutput(originalOutput)
+		SetLogLevel(LevelInfo)
+	}()
+
+	vLog := types.Log{
+		BlockNumber: 12345,
+		Index:       7,
+		TxHash:      common.HexToHash("0xabcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890"),
+	}
+	event := &SwapEvent{Sender: common.HexToAddress("0x1234567890123456789012345678901234567890")}
+	valuation := swapValuation{
+		USDValue:    big.NewFloat(2000),
+		Reserve0:    big.NewInt(100),
+		Reserve1:    big.NewInt(200000),
+		PriceSource: "reserves",
+	}
+
+	logSwapProcessed(vLog, event, valuation, 2000, 100)
+
+	line := buf.String()
+	assert.Contains(t, line, "DEBUG: ")
+	assert.Contains(t, line, "Swap processed")
+
+	var fields map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(line[strings.Index(line, "{"):]), &fields))
+	assert.Equal(t, vLog.TxHash.Hex(), fields["txHash"])
+	assert.Equal(t, 12345.0, fields["block"])
+	assert.Equal(t, event.Sender.Hex(), fields["sender"])
+	assert.Equal(t, "100", fields["reserve0"])
+	assert.Equal(t, "200000", fields["reserve1"])
+	assert.Equal(t, 2000.0, fields["usdValue"])
+	assert.Equal(t, 100.0, fields["points"])
+
+	// Nothing is emitted above DEBUG level
+	buf.Reset()
+	SetLogLevel(LevelInfo)
+	logSwapProcessed(vLog, event, valuation, 2000, 100)
+	assert.Empty(t, buf.String())
+}
+
+func TestRoundUSD(t *testing.T) {
+	tests := []struct {
+		value    *big.Float
+		expected float64
+	}{
+		{big.NewFloat(1.234), 1.23},
+		{big.NewFloat(1.236), 1.24},
+		// Exact half-cent values round to the even cent
+		{big.NewFloat(0.125), 0.12},
+		{big.NewFloat(0.375), 0.38},
+		{big.NewFloat(1.625), 1.62},
+		{big.NewFloat(1.875), 1.88},
+		{big.NewFloat(-0.125), -0.12},
+		{big.NewFloat(-0.375), -0.38},
+		{big.NewFloat(999.999), 1000},
+		{big.NewFloat(0.004), 0},
+		{nil, 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, roundUSD(tt.value), "roundUSD(%v)", tt.value)
+	}
+}
+
+func TestFormatUSD(t *testing.T) {
+	huge := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil))
+	tests := []struct {
+		value    *big.Float
+		expected string
+	}{
+		{big.NewFloat(1000), "1000.00"},
+		{big.NewFloat(1.236), "1.24"},
+		{big.NewFloat(0.125), "0.12"},
+		{big.NewFloat(-0.375), "-0.38"},
+		{big.NewFloat(0.004), "0.00"},
+		{big.NewFloat(1e-12), "0.00"},
+		{big.NewFloat(-0.004), "0.00"},
+		// Too large for a float64 to keep the cents
+		{huge, "1000000000000000000000000000000.00"},
+		{new(big.Float).SetPrec(128).Add(huge, big.NewFloat(0.5)), "1000000000000000000000000000000.50"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, formatUSD(tt.value), "formatUSD(%v)", tt.value)
+	}
+}
+
+func TestSwapEventJSON(t *testing.T) {
+	event := SwapEvent{
+		Sender:     common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"),
+		Amount0In:  big.NewInt(1000000000000000000),
+		Amount1In:  big.NewInt(0),
+		Amount0Out: big.NewInt(0),
+		Amount1Out: big.NewInt(2000000000),
+		To:         common.HexToAddress("0x1234567890123456789012345678901234567890"),
+		USDValue:   big.NewFloat(2000.005),
+	}
+
+	encoded, err := json.Marshal(event)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Sender": "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed",
+		"Amount0In": 1000000000000000000,
+		"Amount1In": 0,
+		"Amount0Out": 0,
+		"Amount1Out": 2000000000,
+		"To": "0x1234567890123456789012345678901234567890",
+		"SqrtPriceX96": null,
+		"Pair": null,
+		"usd_value": "2000.01"
+	}`, string(encoded))
+
+	event.USDValue = nil
+	encoded, err = json.Marshal(&event)
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `"usd_value":null`)
+	assert.NotContains(t, string(encoded), "USDValue")
+}
+
+// latestRoundData encodes a Chainlink latestRoundData response for price (8 decimals) updated at updatedAt
+func latestRoundData(price *big.Int, updatedAt time.Time) []byte {
+	data := make([]byte, 32) // roundId
+	data = append(data, common.LeftPadBytes(price.Bytes(), 32)...)
+	data = append(data, make([]byte, 32)...) // startedAt
+	data = append(data, common.LeftPadBytes(big.NewInt(updatedAt.Unix()).Bytes(), 32)...)
+	return append(data, make([]byte, 32)...) // answeredInRound
+}
+
+func TestGetEthereumPriceStaleness(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	originalStaleness := PriceMaxStaleness
+	defer func() { PriceMaxStaleness = originalStaleness }()
+	PriceMaxStaleness = time.Hour
+
+	stale := time.Now().Add(-2 * time.Hour)
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(latestRoundData(big.NewInt(2000e8), time.Now()), nil).Once()
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(latestRoundData(big.NewInt(2000e8), stale), nil).Once()
+
+	price, err := GetEthereumPrice()
+	assert.NoError(t, err)
+	actual, _ := price.Float64()
+	assert.Equal(t, 2000.0, actual)
+
+	_, err = GetEthereumPrice()
+	var staleErr *StalePriceError
+	if assert.ErrorAs(t, err, &staleErr) {
+		assert.Equal(t, stale.Unix(), staleErr.UpdatedAt.Unix())
+	}
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestCalculateSwapUSDValueWithoutFallbackPrice(t *testing.T) {
+	originalGetPoolReserves := getPoolReservesWrapper
+	defer func() { getPoolReservesWrapper = originalGetPoolReserves }()
+	getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error) {
+		return nil, nil, errors.New("missing trie node")
+	}
+
+	event := &SwapEvent{Amount0In: big.NewInt(1e18), Amount1In: big.NewInt(0), Amount0Out: big.NewInt(0), Amount1Out: big.NewInt(2000e6)}
+
+	// With a stale Chainlink price there is nothing to fall back to
+	_, err := calculateSwapUSDValue(event, 100, nil, PairDecimals{Token0: 18, Token1: 6})
+	assert.Error(t, err)
+}
+
+func TestRPCFailuresAreEthereumErrors(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	rpcErr := errors.New("503 Service Unavailable")
+	mockClient.On("FilterLogs", mock.Anything, mock.Anything).Return([]types.Log(nil), rpcErr).Once()
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return([]byte(nil), rpcErr).Once()
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return([]byte{}, nil).Once()
+
+	_, err := FetchSwapEvents(big.NewInt(1), big.NewInt(2))
+	var ethErr *EthereumError
+	if assert.ErrorAs(t, err, &ethErr) {
+		assert.Equal(t, "FilterLogs", ethErr.Operation)
+		assert.ErrorIs(t, err, rpcErr)
+	}
+
+	_, err = GetEthereumPrice()
+	if assert.ErrorAs(t, err, &ethErr) {
+		assert.Equal(t, "latestRoundData", ethErr.Operation)
+		assert.ErrorIs(t, err, rpcErr)
+	}
+
+	// An empty response cannot be decoded and is reported the same way
+	_, err = GetEthereumPrice()
+	if assert.ErrorAs(t, err, &ethErr) {
+		assert.Equal(t, "latestRoundData", ethErr.Operation)
+	}
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestRPCTimeoutSurfacesAsEthereumError(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	originalTimeout := RPCTimeout
+	defer func() { RPCTimeout = originalTimeout }()
+	RPCTimeout = 2 * time.Second
+
+	hasRPCDeadline := mock.MatchedBy(func(ctx context.Context) bool {
+		deadline, ok := ctx.Deadline()
+		return ok && time.Until(deadline) <= RPCTimeout
+	})
+	mockClient.On("HeaderByNumber", hasRPCDeadline, (*big.Int)(nil)).Return((*types.Header)(nil), context.DeadlineExceeded)
+
+	_, err := GetLatestBlockNumber()
+
+	var ethErr *EthereumError
+	assert.True(t, errors.As(err, &ethErr), "expected an EthereumError, got %v", err)
+	assert.Equal(t, "HeaderByNumber", ethErr.Operation)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPriceBreakerTransitions(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	originalBreaker := ethPriceBreaker
+	defer func() { ethPriceBreaker = originalBreaker }()
+	now := time.Now()
+	ethPriceBreaker = newPriceBreaker(2, time.Minute)
+	ethPriceBreaker.now = func() time.Time { return now }
+
+	rpcErr := errors.New("503 Service Unavailable")
+	price := func() float64 {
+		quote, err := GetEthereumPriceQuote()
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		value, _ := quote.Price.Float64()
+		return value
+	}
+
+	// Closed: a good price is fetched and remembered
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(latestRoundData(big.NewInt(2000e8), now), nil).Once()
+	assert.Equal(t, 2000.0, price())
+
+	// Two consecutive failures open the breaker
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return([]byte(nil), rpcErr).Twice()
+	_, err := GetEthereumPriceQuote()
+	assert.ErrorIs(t, err, rpcErr)
+	assert.Equal(t, breakerClosed, ethPriceBreaker.state)
+	_, err = GetEthereumPriceQuote()
+	assert.ErrorIs(t, err, rpcErr)
+	assert.Equal(t, breakerOpen, ethPriceBreaker.state)
+
+	// Open: the cached price is served without calling the RPC
+	quote, err := GetEthereumPriceQuote()
+	assert.NoError(t, err)
+	assert.True(t, quote.Cached)
+	assert.Equal(t, now.Unix(), quote.UpdatedAt.Unix())
+	mockClient.AssertNumberOfCalls(t, "CallContract", 3)
+
+	// Half-open: after the cooldown a failed probe reopens the breaker
+	now = now.Add(time.Minute)
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return([]byte(nil), rpcErr).Once()
+	_, err = GetEthereumPriceQuote()
+	assert.ErrorIs(t, err, rpcErr)
+	assert.Equal(t, breakerOpen, ethPriceBreaker.state)
+	quote, err = GetEthereumPriceQuote()
+	assert.NoError(t, err)
+	assert.True(t, quote.Cached)
+
+	// A successful probe closes the breaker again
+	now = now.Add(time.Minute)
+	mockClient.On("CallContract", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(latestRoundData(big.NewInt(2100e8), now), nil).Once()
+	assert.Equal(t, 2100.0, price())
+	assert.Equal(t, breakerClosed, ethPriceBreaker.state)
+	assert.Equal(t, 0, ethPriceBreaker.failures)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPriceBreakerOpenWithoutCachedPrice(t *testing.T) {
+	originalBreaker := ethPriceBreaker
+	defer func() { ethPriceBreaker = originalBreaker }()
+	ethPriceBreaker = newPriceBreaker(1, time.Minute)
+
+	ethPriceBreaker.record(PriceQuote{}, &EthereumError{Operation: "latestRoundData", Err: errors.New("timeout")})
+	assert.False(t, ethPriceBreaker.allow())
+
+	_, err := GetEthereumPriceQuote()
+	assert.ErrorIs(t, err, errPriceCircuitOpen)
+
+	// A cached price older than PriceMaxStaleness is not served either
+	originalStaleness := PriceMaxStaleness
+	defer func() { PriceMaxStaleness = originalStaleness }()
+	PriceMaxStaleness = time.Hour
+	ethPriceBreaker.last = &PriceQuote{Price: big.NewFloat(2000), UpdatedAt: time.Now().Add(-2 * time.Hour)}
+
+	_, err = GetEthereumPriceQuote()
+	var staleErr *StalePriceError
+	assert.ErrorAs(t, err, &staleErr)
+}
+
+// uniswapV3SwapLog builds a Swap log as emitted by the Uniswap V3 WETH/USDT 0.05% pool
+// (0x11b815efB8f581194ae79006d24E0d814B7697F6), where token0 is WETH and token1 USDT
+func uniswapV3SwapLog(t *testing.T, sender, recipient common.Address, amount0, amount1, sqrtPriceX96 *big.Int) types.Log {
+	data, err := swapEventV3ABI.Events["Swap"].Inputs.NonIndexed().Pack(amount0, amount1, sqrtPriceX96, big.NewInt(5e17), big.NewInt(-200311))
+	if err != nil {
+		t.Fatalf("failed to pack V3 swap log: %v", err)
+	}
+	return types.Log{
+		Address: common.HexToAddress("0x11b815efB8f581194ae79006d24E0d814B7697F6"),
+		Topics: []common.Hash{
+			crypto.Keccak256Hash(SwapEventV3Signature),
+			common.BytesToHash(sender.Bytes()),
+			common.BytesToHash(recipient.Bytes()),
+		},
+		Data:        data,
+		BlockNumber: 19000000,
+		TxHash:      common.HexToHash("0x3c1b5a3e4f4d2b0e8a6f1c9d7e5b3a1f0e2d4c6b8a9f7e5d3c1b2a4f6e8d0c9b"),
+	}
+}
+
+func TestUnpackSwapLogV3(t *testing.T) {
+	sender := common.HexToAddress("0xE592427A0AEce92De3Edee1F18E0157C05861564") // SwapRouter
+	recipient := common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	sqrtPriceX96, _ := new(big.Int).SetString("3543191142285914205922034", 10) // 2000 USDT per WETH
+
+	// 1 WETH sold into the pool for 1999.5 USDT
+	vLog := uniswapV3SwapLog(t, sender, recipient, big.NewInt(1e18), big.NewInt(-1999500000), sqrtPriceX96)
+
+	event, err := unpackSwapLog(vLog)
+	assert.NoError(t, err)
+	assert.Equal(t, sender, event.Sender)
+	assert.Equal(t, recipient, event.To)
+	assert.Equal(t, big.NewInt(1e18), event.Amount0In)
+	assert.Equal(t, big.NewInt(0), event.Amount0Out)
+	assert.Equal(t, big.NewInt(0), event.Amount1In)
+	assert.Equal(t, big.NewInt(1999500000), event.Amount1Out)
+	assert.Equal(t, sqrtPriceX96, event.SqrtPriceX96)
+	assert.True(t, swapAmountsValid(&event))
+
+	// V3 swaps are valued from sqrtPriceX96 without reading reserves
+	originalGetPoolReserves := getPoolReservesWrapper
+	defer func() { getPoolReservesWrapper = originalGetPoolReserves }()
+	getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error) {
+		t.Fatal("unexpected reserves lookup for a V3 swap")
+		return nil, nil, nil
+	}
+
+	valuation, err := calculateSwapUSDValue(&event, vLog.BlockNumber, nil, PairDecimals{Token0: 18, Token1: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, "sqrtPriceX96", valuation.PriceSource)
+	assert.InDelta(t, 2000.0, roundUSD(valuation.USDValue), 0.01)
+
+	// USDT paid in is valued directly
+	event, err = unpackSwapLog(uniswapV3SwapLog(t, sender, recipient, big.NewInt(-5e17), big.NewInt(1001000000), sqrtPriceX96))
+	assert.NoError(t, err)
+	usdValue, err := calculateUSDValueFromSqrtPrice(&event, event.SqrtPriceX96, PairDecimals{Token0: 18, Token1: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, 1001.0, roundUSD(usdValue))
+}
+
+// TestSwapRecipientOnEveryParsePath checks that To is filled however a swap log is parsed;
+// custom events are covered by TestUnpackSwapLogCustomEvent
+func TestSwapRecipientOnEveryParsePath(t *testing.T) {
+	sender := common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D") // Uniswap V2 router
+	recipient := common.HexToAddress("0x0987654321098765432109876543210987654321")
+
+	t.Run("uniswap v2", func(t *testing.T) {
+		event, err := unpackSwapLog(newSwapLog(sender, common.HexToHash("0x01"), 100, big.NewInt(1e18), big.NewInt(2000e6)))
+		assert.NoError(t, err)
+		assert.Equal(t, recipient, event.To)
+	})
+
+	t.Run("uniswap v3", func(t *testing.T) {
+		sqrtPriceX96, _ := new(big.Int).SetString("3543191142285914205922034", 10)
+		event, err := unpackSwapLog(uniswapV3SwapLog(t, sender, recipient, big.NewInt(1e18), big.NewInt(-1999500000), sqrtPriceX96))
+		assert.NoError(t, err)
+		assert.Equal(t, recipient, event.To)
+	})
+
+	t.Run("offline client", func(t *testing.T) {
+		db, dbMock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		SetDB(db)
+		dbMock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(time.Now().Add(-24*time.Hour), time.Now().Add(27*24*time.Hour), true, false))
+		dbMock.ExpectExec("INSERT INTO audit_swap_processing").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Client = offlineClient{}
+		tokenMetadata = newTokenCache()
+		originalRecordSwap := recordSwapWrapper
+		defer func() { recordSwapWrapper = originalRecordSwap }()
+		recordSwapWrapper = newFakeSwapRecorder().record
+
+		// The offline pair has USDC as token0: 2000 USDC in for 1 WETH out
+		swapEvents, err := ProcessSwapEvents([]types.Log{newSwapLog(sender, common.HexToHash("0x02"), offlineBlockNumber, big.NewInt(2000e6), big.NewInt(1e18))})
+		assert.NoError(t, err)
+		if assert.Len(t, swapEvents, 1) {
+			assert.Equal(t, recipient, swapEvents[0].To)
+			assert.Equal(t, 2000.0, roundUSD(swapEvents[0].USDValue))
+		}
+	})
+}
+
+func TestUnpackSwapLogCustomEvent(t *testing.T) {
+	// A fork whose event has a different name and argument names but the same shape
+	const forkABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"trader","type":"address"},{"indexed":false,"name":"inA","type":"uint256"},{"indexed":false,"name":"inB","type":"uint256"},{"indexed":false,"name":"outA","type":"uint256"},{"indexed":false,"name":"outB","type":"uint256"},{"indexed":true,"name":"receiver","type":"address"}],"name":"Swapped","type":"event"}]`
+	event, err := parseSwapEvent(forkABI, "Swapped", "Swapped(address, uint256, uint256, uint256, uint256, address)")
+	assert.NoError(t, err)
+	assert.Equal(t, crypto.Keccak256Hash([]byte("Swapped(address,uint256,uint256,uint256,uint256,address)")), event.ID)
+
+	originalEvent := trackedSwapEvent
+	defer func() { trackedSwapEvent = originalEvent }()
+	trackedSwapEvent = event
+	assert.Equal(t, event.ID, swapEventTopic())
+
+	sender := common.HexToAddress("0xd9e1cE17f2641f24aE83637ab66a2cca9C378B9F") // SushiSwap router
+	receiver := common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	data, err := event.Inputs.NonIndexed().Pack(big.NewInt(0), big.NewInt(2000e6), big.NewInt(1e18), big.NewInt(0))
+	assert.NoError(t, err)
+
+	swap, err := unpackSwapLog(types.Log{
+		Topics: []common.Hash{event.ID, common.BytesToHash(sender.Bytes()), common.BytesToHash(receiver.Bytes())},
+		Data:   data,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, sender, swap.Sender)
+	assert.Equal(t, receiver, swap.To)
+	assert.Equal(t, "0", swap.Amount0In.String())
+	assert.Equal(t, "2000000000", swap.Amount1In.String())
+	assert.Equal(t, "1000000000000000000", swap.Amount0Out.String())
+	assert.Equal(t, "0", swap.Amount1Out.String())
+}
+
+func TestParseSwapEventRejectsInvalidConfig(t *testing.T) {
+	_, err := parseSwapEvent("not json", "Swap", "")
+	assert.ErrorContains(t, err, "invalid swap event ABI")
+
+	_, err = parseSwapEvent(uniswapV2SwapABI, "Swapped", "")
+	assert.ErrorContains(t, err, `no event "Swapped"`)
+
+	_, err = parseSwapEvent(uniswapV2SwapABI, "Swap", "Swap(address,uint256,uint256,address)")
+	assert.ErrorContains(t, err, "does not match")
+
+	_, err = parseSwapEvent(strings.Replace(uniswapV2SwapABI, `"name":"amount1Out","type":"uint256"`, `"name":"amount1Out","type":"int256"`, 1), "Swap", "")
+	assert.ErrorContains(t, err, `input "amount1Out"`)
+
+	// An invalid event configured at startup is reported when connecting
+	originalErr := swapEventConfigErr
+	defer func() { swapEventConfigErr = originalErr }()
+	_, swapEventConfigErr = parseSwapEvent(uniswapV2SwapABI, "Swapped", "")
+	err = InitEthereumClient(func(url string) (EthereumClient, error) {
+		t.Fatalf("unexpected connection to %q", url)
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, swapEventConfigErr)
+}
+
+func TestFetchSwapEventsFiltersByPoolVersion(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	originalVersion := PoolVersion
+	defer func() { PoolVersion = originalVersion }()
+
+	for version, signature := range map[string][]byte{PoolVersionV2: SwapEventSignature, PoolVersionV3: SwapEventV3Signature} {
+		PoolVersion = version
+		topic := crypto.Keccak256Hash(signature)
+		mockClient.On("FilterLogs", mock.Anything, mock.MatchedBy(func(q ethereum.FilterQuery) bool {
+			return len(q.Topics) == 1 && len(q.Topics[0]) == 1 && q.Topics[0][0] == topic
+		})).Return([]types.Log{}, nil).Once()
+
+		_, err := FetchSwapEvents(big.NewInt(1), big.NewInt(2))
+		assert.NoError(t, err, version)
+	}
+
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// unhealthyCooldown is how long a failed endpoint is deprioritized before it is preferred again
+const unhealthyCooldown = 30 * time.Second
+
+// rpcEndpoint is a single RPC endpoint tracked by the FailoverClient
+type rpcEndpoint struct {
+	url         string
+	client      EthereumClient
+	healthy     bool
+	lastFailure time.Time
+}
+
+// FailoverClient wraps several Ethereum clients and sends each call to the first
+// healthy endpoint, falling back to the next one on connection or 5xx errors
+type FailoverClient struct {
+	mu        sync.Mutex
+	endpoints []*rpcEndpoint
+}
+
+// NewFailoverClient creates a FailoverClient; urls and clients must be in the same order,
+// with the primary endpoint first
+func NewFailoverClient(urls []string, clients []EthereumClient) *FailoverClient {
+	endpoints := make([]*rpcEndpoint, len(clients))
+	for i, client := range clients {
+		endpoints[i] = &rpcEndpoint{url: urls[i], client: client, healthy: true}
+	}
+	return &FailoverClient{endpoints: endpoints}
+}
+
+// Close closes every endpoint's client that supports closing
+func (f *FailoverClient) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, ep := range f.endpoints {
+		if closer, ok := ep.client.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// orderedEndpoints returns healthy endpoints first (in configured order), followed by unhealthy ones
+func (f *FailoverClient) orderedEndpoints() []*rpcEndpoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var healthy, unhealthy []*rpcEndpoint
+	for _, ep := range f.endpoints {
+		if !ep.healthy && time.Since(ep.lastFailure) > unhealthyCooldown {
+			ep.healthy = true
+		}
+		if ep.healthy {
+			healthy = append(healthy, ep)
+		} else {
+			unhealthy = append(unhealthy, ep)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (f *FailoverClient) markHealthy(ep *rpcEndpoint, healthy bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ep.healthy = healthy
+	if !healthy {
+		ep.lastFailure = time.Now()
+	}
+}
+
+// isFailoverError reports whether err indicates the endpoint itself is unavailable,
+// as opposed to an error in the request that every endpoint would return
+func isFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "EOF")
+}
+
+// endpointContext bounds one attempt to an equal share of the time left on ctx among the
+// endpoints still to try, so a single slow endpoint cannot use up the caller's whole deadline
+func endpointContext(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+}
+
+func failoverCall[T any](ctx context.Context, f *FailoverClient, method string, call func(context.Context, EthereumClient) (T, error)) (T, error) {
+	var (
+		result T
+		err    error
+	)
+	endpoints := f.orderedEndpoints()
+	for i, ep := range endpoints {
+		attemptCtx, cancel := endpointContext(ctx, len(endpoints)-i)
+		result, err = call(attemptCtx, ep.client)
+		cancel()
+		if err == nil {
+			f.markHealthy(ep, true)
+			LogDebug("RPC call %s served by %s", method, ep.url)
+			return result, nil
+		}
+		// Once the caller's own deadline has passed every endpoint would fail the same way,
+		// so it says nothing about this endpoint's health
+		if ctx.Err() != nil || !isFailoverError(err) {
+			return result, err
+		}
+		f.markHealthy(ep, false)
+		LogError("RPC call %s failed on %s, trying next endpoint: %v", method, ep.url, err)
+	}
+	return result, err
+}
+
+func (f *FailoverClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return failoverCall(ctx, f, "CodeAt", func(ctx context.Context, c EthereumClient) ([]byte, error) {
+		return c.CodeAt(ctx, contract, blockNumber)
+	})
+}
+
+func (f *FailoverClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return failoverCall(ctx, f, "CallContract", func(ctx context.Context, c EthereumClient) ([]byte, error) {
+		return c.CallContract(ctx, call, blockNumber)
+	})
+}
+
+func (f *FailoverClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return failoverCall(ctx, f, "HeaderByNumber", func(ctx context.Context, c EthereumClient) (*types.Header, error) {
+		return c.HeaderByNumber(ctx, number)
+	})
+}
+
+func (f *FailoverClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return failoverCall(ctx, f, "BlockNumber", func(ctx context.Context, c EthereumClient) (uint64, error) {
+		return c.BlockNumber(ctx)
+	})
+}
+
+func (f *FailoverClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	return failoverCall(ctx, f, "FilterLogs", func(ctx context.Context, c EthereumClient) ([]types.Log, error) {
+		return c.FilterLogs(ctx, q)
+	})
+}
+
+func (f *FailoverClient) ChainID(ctx context.Context) (*big.Int, error) {
+	return failoverCall(ctx, f, "ChainID", func(ctx context.Context, c EthereumClient) (*big.Int, error) {
+		return c.ChainID(ctx)
+	})
+}
+
+func (f *FailoverClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	_, err := failoverCall(ctx, f, "SendTransaction", func(ctx context.Context, c EthereumClient) (struct{}, error) {
+		return struct{}{}, c.SendTransaction(ctx, tx)
+	})
+	return err
+}
+
+func (f *FailoverClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return failoverCall(ctx, f, "SuggestGasPrice", func(ctx context.Context, c EthereumClient) (*big.Int, error) {
+		return c.SuggestGasPrice(ctx)
+	})
+}
+
+func (f *FailoverClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return failoverCall(ctx, f, "EstimateGas", func(ctx context.Context, c EthereumClient) (uint64, error) {
+		return c.EstimateGas(ctx, call)
+	})
+}
+
+func (f *FailoverClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return failoverCall(ctx, f, "TransactionReceipt", func(ctx context.Context, c EthereumClient) (*types.Receipt, error) {
+		return c.TransactionReceipt(ctx, txHash)
+	})
+}
@@ -1,23 +1,104 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
+// LogLevel controls which messages are emitted
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelError: "error",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel parses "debug", "info" or "error", ignoring case
+func ParseLogLevel(s string) (LogLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Fields holds structured key/value data attached to a log record
+type Fields map[string]interface{}
+
+// Loggers are created in variable initializers rather than init() so they are
+// usable from the init() functions of every other file
 var (
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
+	debugLogger = log.New(os.Stdout, "DEBUG: ", log.Ldate|log.Ltime)
+	infoLogger  = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
+	errorLogger = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime)
+	// logLevel is atomic so it can be changed at runtime through the admin API
+	logLevel atomic.Int32
 )
 
 func init() {
-	infoLogger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
-	errorLogger = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime)
+	logLevel.Store(int32(LevelInfo))
+	if debug, _ := strconv.ParseBool(os.Getenv("DEBUG")); debug {
+		SetLogLevel(LevelDebug)
+	}
+}
+
+// SetLogLevel sets the minimum level of messages that are emitted
+func SetLogLevel(level LogLevel) {
+	logLevel.Store(int32(level))
+}
+
+// GetLogLevel returns the minimum level of messages that are emitted
+func GetLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+func LogDebug(format string, v ...interface{}) {
+	if GetLogLevel() > LevelDebug {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	_, file, line, _ := runtime.Caller(1)
+	debugLogger.Printf("[%s:%d] %s", file, line, msg)
+}
+
+// LogDebugFields emits msg at DEBUG level followed by fields encoded as a single JSON object
+func LogDebugFields(msg string, fields Fields) {
+	if GetLogLevel() > LevelDebug {
+		return
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%q", fmt.Sprint(fields)))
+	}
+	_, file, line, _ := runtime.Caller(1)
+	debugLogger.Printf("[%s:%d] %s %s", file, line, msg, encoded)
 }
 
 func LogInfo(format string, v ...interface{}) {
+	if GetLogLevel() > LevelInfo {
+		return
+	}
 	msg := fmt.Sprintf(format, v...)
 	_, file, line, _ := runtime.Caller(1)
 	infoLogger.Printf("[%s:%d] %s", file, line, msg)
@@ -2,12 +2,41 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
+	"errors"
 	"log"
 	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 )
 
+// Default HTTP server timeouts, overridable via environment variables
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 15 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	// defaultStreamWriteTimeout replaces the write timeout for exports, NDJSON streams and backfills
+	defaultStreamWriteTimeout = 30 * time.Minute
+)
+
+const (
+	// swapPollInterval is the delay between swap processing batches
+	swapPollInterval = 15 * time.Second
+	// defaultDrainTimeout bounds how long shutdown waits for the in-flight swap batch,
+	// overridable via SHUTDOWN_DRAIN_TIMEOUT
+	defaultDrainTimeout = 30 * time.Second
+	// defaultShutdownTimeout bounds how long shutdown waits for open HTTP requests
+	defaultShutdownTimeout = 10 * time.Second
+	// defaultCampaignCheckInterval is how often the campaign end time is checked,
+	// overridable via CAMPAIGN_CHECK_INTERVAL
+	defaultCampaignCheckInterval = time.Hour
+)
+
 func main() {
 	LogInfo("Trading Ace starting...")
 
@@ -15,16 +44,28 @@ func main() {
 	if err != nil {
 		LogFatal("Failed to initialize database: %v", err)
 	}
-	defer DB.Close()
+	defer DB().Close()
 
 	err = InitEthereumClient(nil) // Use the default client creator
 	if err != nil {
 		LogFatal("Failed to initialize Ethereum client: %v", err)
 	}
+	defer CloseEthereumClient()
+
+	if err := ValidateTrackedPair(); isFailoverError(err) {
+		// An unreachable node says nothing about the pair; swap processing retries the reads
+		LogError("Could not validate PAIR_ADDRESS %s, continuing: %v", UniswapV2PairAddress, err)
+	} else if err != nil {
+		LogFatal("Invalid PAIR_ADDRESS %s: %v", UniswapV2PairAddress, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Set up and run the API server
-	r := SetupRouter()
+	server := newHTTPServer(":8080", SetupRouter())
 	go func() {
-		if err := r.Run(":8080"); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Failed to run server: %v", err)
 		}
 	}()
@@ -32,60 +73,230 @@ func main() {
 	// Start the weekly share pool task
 	go runWeeklySharePoolTask()
 
+	// Deactivate the campaign once its end time has passed
+	go runCampaignEndTask(envDuration("CAMPAIGN_CHECK_INTERVAL", defaultCampaignCheckInterval))
+
 	// Fetch and process swap events continuously
+	processorDone := make(chan struct{})
 	go func() {
-		for {
-			// Fetch swap events for the last 100 blocks
-			latestBlock, err := Client.BlockNumber(context.Background())
-			if err != nil {
-				log.Printf("Failed to get latest block number: %v", err)
-				time.Sleep(15 * time.Second)
-				continue
-			}
+		defer close(processorDone)
+		runSwapProcessor(ctx, swapPollInterval, processLatestSwaps)
+	}()
 
-			fmt.Println("Processing blocks up to:", latestBlock)
+	<-ctx.Done()
+	LogInfo("Shutting down, waiting for the in-flight swap batch to finish")
 
-			fromBlock := big.NewInt(int64(latestBlock - 100))
-			toBlock := big.NewInt(int64(latestBlock))
+	if !waitForDrain(processorDone, envDuration("SHUTDOWN_DRAIN_TIMEOUT", defaultDrainTimeout)) {
+		LogError("Swap processor did not finish within the drain timeout")
+	}
 
-			logs, err := FetchSwapEvents(fromBlock, toBlock)
-			if err != nil {
-				log.Printf("Failed to fetch swap events: %v", err)
-				time.Sleep(15 * time.Second)
-				continue
-			}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		LogError("Failed to shut down HTTP server: %v", err)
+	}
+	LogInfo("Trading Ace stopped")
+}
 
-			ProcessSwapEvents(logs)
+// runSwapProcessor calls processBatch every interval until ctx is cancelled. Cancellation is
+// only observed between batches, so a batch in progress always runs to completion.
+func runSwapProcessor(ctx context.Context, interval time.Duration, processBatch func()) {
+	for ctx.Err() == nil {
+		processBatch()
 
-			time.Sleep(15 * time.Second) // Wait for 15 seconds before next fetch
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
 		}
-	}()
+	}
+}
+
+// swapScanBlocks is how many confirmed blocks each poll of processLatestSwaps covers
+const swapScanBlocks = 100
 
-	// Keep the main goroutine running
-	select {}
+// confirmedBlockRange returns the last window blocks that have at least confirmations
+// confirmations, or false if no block is confirmed yet
+func confirmedBlockRange(latestBlock, confirmations, window uint64) (fromBlock, toBlock uint64, ok bool) {
+	if latestBlock < confirmations {
+		return 0, 0, false
+	}
+	toBlock = latestBlock - confirmations
+	if toBlock >= window {
+		fromBlock = toBlock - window
+	}
+	return fromBlock, toBlock, true
+}
+
+// processLatestSwaps fetches and processes swap events for the last 100 confirmed blocks.
+// Blocks within SwapConfirmations of the head are left for a later poll.
+func processLatestSwaps() {
+	ctx, cancel := rpcContext()
+	latestBlock, err := Client.BlockNumber(ctx)
+	cancel()
+	if err != nil {
+		LogError("Failed to get latest block number: %v", err)
+		return
+	}
+	recordSwapLag(latestBlock)
+
+	from, to, ok := confirmedBlockRange(latestBlock, SwapConfirmations, swapScanBlocks)
+	if !ok {
+		LogInfo("No blocks with %d confirmations yet at block %d", SwapConfirmations, latestBlock)
+		return
+	}
+
+	LogDebug("Processing blocks %d to %d", from, to)
+
+	fromBlock := new(big.Int).SetUint64(from)
+	toBlock := new(big.Int).SetUint64(to)
+
+	logs, err := FetchSwapEvents(fromBlock, toBlock)
+	if err != nil {
+		LogError("Failed to fetch swap events: %v", err)
+		return
+	}
+
+	// The block is only marked processed once every swap in it was, so the lag gauge keeps
+	// growing while batches fail
+	if _, err := ProcessSwapEvents(logs); err != nil {
+		LogError("Failed to process swap events for blocks %d to %d: %v", from, to, err)
+		return
+	}
+	lastProcessedBlock.Store(to)
+}
+
+// waitForDrain waits for done to be closed, giving up after timeout
+func waitForDrain(done <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
+// sharePoolRecheckInterval is how often the weekly task looks for a new campaign once every
+// week of the current one has ended, or while the campaign cannot be read
+const sharePoolRecheckInterval = time.Hour
+
+// runWeeklySharePoolTask pays out each campaign week when it ends. Every run also pays weeks
+// that were missed, so the first run at startup catches up on weeks that ended while the
+// service was down.
 func runWeeklySharePoolTask() {
 	for {
-		// Wait until the next Monday at 00:00 UTC
-		nextMonday := getNextMonday()
-		time.Sleep(time.Until(nextMonday))
-
 		log.Println("Starting weekly share pool calculation")
-		err := CalculateWeeklySharePoolPoints()
-		if err != nil {
+		if err := catchUpWeeklySharePools(time.Now()); err != nil {
 			log.Printf("Error calculating weekly share pool points: %v", err)
 		}
+
+		next, ok := time.Time{}, false
+		config, err := GetCampaignConfig()
+		if err == nil {
+			next, ok = nextSharePoolRun(config, time.Now())
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			LogError("Failed to schedule the weekly share pool: %v", err)
+		}
+		if !ok {
+			next = time.Now().Add(sharePoolRecheckInterval)
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// finalDistributionOnEnd pays out the campaign's last, possibly partial, week as soon as the
+// campaign is ended instead of at the next weekly run. Disable with FINAL_DISTRIBUTION_ON_END=false.
+var finalDistributionOnEnd = os.Getenv("FINAL_DISTRIBUTION_ON_END") != "false"
+
+func runCampaignEndTask(interval time.Duration) {
+	for {
+		time.Sleep(checkCampaignEnd(time.Now(), interval))
+	}
+}
+
+// checkCampaignEnd ends the campaign if now is past its end time and returns how long to wait
+// before the next check: interval, or less when the end time falls before then
+func checkCampaignEnd(now time.Time, interval time.Duration) time.Duration {
+	config, err := GetCampaignConfig()
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			LogError("Failed to check campaign end: %v", err)
+		}
+		return interval
+	}
+	if !config.IsActive {
+		return interval
+	}
+
+	if !now.Before(config.EndTime) {
+		if err := EndCampaign(); err != nil {
+			LogError("Failed to end campaign: %v", err)
+			return interval
+		}
+		LogInfo("Campaign %d ended at %s, deactivated", config.ID, config.EndTime)
+		if finalDistributionOnEnd {
+			if err := calculateWeeklySharePoolPoints(now); err != nil {
+				LogError("Failed to run final distribution for campaign %d: %v", config.ID, err)
+			}
+		}
+		return interval
+	}
+
+	if untilEnd := config.EndTime.Sub(now); untilEnd < interval {
+		return untilEnd
+	}
+	return interval
+}
+
+// nextSharePoolRun returns the end of the current campaign week, when its share pool is due.
+// ok is false once every week of the campaign has ended.
+func nextSharePoolRun(config CampaignConfig, now time.Time) (next time.Time, ok bool) {
+	if !now.Before(config.EndTime) {
+		return time.Time{}, false
+	}
+	week := config.CurrentWeek(now)
+	if week < 1 {
+		week = 1
 	}
+	_, next = config.WeekBounds(week)
+	return next, true
 }
 
-func getNextMonday() time.Time {
-	now := time.Now().UTC()
-	weekday := int(now.Weekday())
-	daysUntilMonday := 7 - weekday
-	if weekday == 0 { // If it's Sunday
-		daysUntilMonday = 1
+// newHTTPServer creates the API server with bounded timeouts so slow clients cannot hold connections open
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", defaultReadTimeout),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", defaultWriteTimeout),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", defaultIdleTimeout),
+	}
+}
+
+// envUint reads a non-negative integer from the environment, falling back to def when unset or invalid
+func envUint(key string, def uint64) uint64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		LogError("Invalid number %q for %s, using default %d", value, key, def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a duration such as "30s" from the environment, returning def when unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		LogError("Invalid duration %q for %s, using default %s", value, key, def)
+		return def
 	}
-	nextMonday := now.AddDate(0, 0, daysUntilMonday)
-	return time.Date(nextMonday.Year(), nextMonday.Month(), nextMonday.Day(), 0, 0, 0, 0, time.UTC)
+	return d
 }
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -21,7 +29,7 @@ func TestGetUserTasks(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
 
 	// Mock the user query
 	userRows := sqlmock.NewRows([]string{"id", "onboarding_completed", "onboarding_points", "onboarding_amount"}).
@@ -32,26 +40,26 @@ func TestGetUserTasks(t *testing.T) {
 		WillReturnRows(userRows)
 
 	// Mock the swap events query
-	swapRows := sqlmock.NewRows([]string{"total_amount", "total_points"}).
-		AddRow(5000.0, 500)
+	swapRows := sqlmock.NewRows([]string{"total_amount", "share_pool_points", "total_points", "earned_points"}).
+		AddRow(5000.0, 500, 600, 600)
 
-	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), COALESCE").
+	// Share pool points are read back with the same reason the weekly distribution writes
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), COALESCE(.+)reason = 'Weekly Share Pool Task'").
 		WithArgs(1).
 		WillReturnRows(swapRows)
 
 	// Mock the campaign config query
-	configRows := sqlmock.NewRows([]string{"id", "start_time", "end_time", "is_active"}).
-		AddRow(1, time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true)
+	configRows := campaignConfigRows(time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true, false)
 
-	mock.ExpectQuery("SELECT id, start_time, end_time, is_active FROM campaign_config").
+	mock.ExpectQuery(campaignConfigQuery).
 		WillReturnRows(configRows)
 
 	// Mock the latest distribution query
 	distRows := sqlmock.NewRows([]string{"latest_distribution"}).
 		AddRow(time.Now().Add(-8 * 24 * time.Hour))
 
-	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(timestamp\\), \\$1\\)").
-		WithArgs(sqlmock.AnyArg(), 1).
+	mock.ExpectQuery("SELECT MAX\\(timestamp\\) FROM points_history WHERE user_id = \\$1 AND reason = 'Weekly Share Pool Task'").
+		WithArgs(1).
 		WillReturnRows(distRows)
 
 	tasks, err := GetUserTasks("0x1234567890123456789012345678901234567890")
@@ -63,8 +71,171 @@ func TestGetUserTasks(t *testing.T) {
 	assert.Equal(t, 1000.0, tasks["onboarding"].(map[string]interface{})["amount"])
 	assert.Equal(t, 5000.0, tasks["sharePool"].(map[string]interface{})["amount"])
 	assert.Equal(t, 500.0, tasks["sharePool"].(map[string]interface{})["points"])
+	// Total points covers onboarding (100) plus share pool (500) points
+	assert.Equal(t, int64(600), tasks["totalPoints"])
+	assert.Equal(t, "points", tasks["pointsLabel"])
 	assert.True(t, tasks["sharePool"].(map[string]interface{})["eligible"].(bool))
 	assert.NotNil(t, tasks["campaign"])
+	assert.Equal(t, common.HexToAddress("0x1234567890123456789012345678901234567890").Hex(), tasks["address"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUserTasksReorgReversalLowersTotal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery("SELECT id, onboarding_completed, onboarding_points, COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "onboarding_completed", "onboarding_points", "onboarding_amount"}).
+			AddRow(1, true, 100, 1000.0))
+	// A 200 point reorg reversal lowers the total but not the points earned from tasks
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), (.+)reason <> 'Reorg reversal' AND reason NOT LIKE 'Admin adjustment%'").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount", "share_pool_points", "total_points", "earned_points"}).
+			AddRow(5000.0, 500, 400, 600))
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-CampaignWeek), time.Now().Add(3*CampaignWeek), true, false))
+	mock.ExpectQuery("SELECT MAX\\(timestamp\\) FROM points_history").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+
+	tasks, err := GetUserTasks("0x1234567890123456789012345678901234567890")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400), tasks["totalPoints"])
+	assert.Equal(t, int64(600), tasks["earnedPoints"])
+	// Task completion and share pool points are unaffected by the reversal
+	assert.Equal(t, true, tasks["onboarding"].(map[string]interface{})["completed"])
+	assert.Equal(t, true, tasks["sharePool"].(map[string]interface{})["completed"])
+	assert.Equal(t, 500.0, tasks["sharePool"].(map[string]interface{})["points"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUserTasksEligibilityFollowsCampaignWeeks(t *testing.T) {
+	// The campaign is in week 2, which started 2 days ago
+	campaignStart := time.Now().Add(-9 * 24 * time.Hour)
+	currentWeekStart := campaignStart.Add(CampaignWeek)
+
+	tests := []struct {
+		name               string
+		latestDistribution interface{}
+		eligible           bool
+	}{
+		{"never distributed", nil, true},
+		{"distributed last week", currentWeekStart.Add(-CampaignWeek), true},
+		// A rolling 7-day window would still count this as eligible after 6 days
+		{"distributed this week", currentWeekStart, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			SetDB(db)
+
+			mock.ExpectQuery("SELECT id, onboarding_completed, onboarding_points, COALESCE").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "onboarding_completed", "onboarding_points", "onboarding_amount"}).
+					AddRow(1, true, 100, 1000.0))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), COALESCE").
+				WillReturnRows(sqlmock.NewRows([]string{"total_amount", "share_pool_points", "total_points", "earned_points"}).
+					AddRow(5000.0, 500, 600, 600))
+			mock.ExpectQuery(campaignConfigQuery).
+				WillReturnRows(campaignConfigRows(campaignStart, campaignStart.Add(CampaignWeeks*CampaignWeek), true, false))
+			mock.ExpectQuery("SELECT MAX\\(timestamp\\) FROM points_history").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(tt.latestDistribution))
+
+			tasks, err := GetUserTasks("0x1234567890123456789012345678901234567890")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.eligible, tasks["sharePool"].(map[string]interface{})["eligible"])
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestGetUserTasksDistributionQueryFails(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		SetDB(db)
+
+		originalStrict := userTasksStrict
+		userTasksStrict = strict
+
+		mock.ExpectQuery("SELECT id, onboarding_completed, onboarding_points, COALESCE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "onboarding_completed", "onboarding_points", "onboarding_amount"}).
+				AddRow(1, true, 100, 1000.0))
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), COALESCE").
+			WillReturnRows(sqlmock.NewRows([]string{"total_amount", "share_pool_points", "total_points", "earned_points"}).
+				AddRow(5000.0, 500, 600, 600))
+		mock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(time.Now().Add(-CampaignWeek), time.Now().Add(3*CampaignWeek), true, false))
+		mock.ExpectQuery("SELECT MAX\\(timestamp\\) FROM points_history").
+			WillReturnError(sql.ErrConnDone)
+
+		tasks, err := GetUserTasks("0x1234567890123456789012345678901234567890")
+		if strict {
+			assert.ErrorIs(t, err, sql.ErrConnDone)
+		} else {
+			// Onboarding and share pool totals are still returned; only eligibility is unknown
+			assert.NoError(t, err)
+			assert.Equal(t, true, tasks["partial"])
+			assert.Equal(t, true, tasks["onboarding"].(map[string]interface{})["completed"])
+			assert.Equal(t, 500.0, tasks["sharePool"].(map[string]interface{})["points"])
+			assert.Nil(t, tasks["sharePool"].(map[string]interface{})["eligible"])
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		userTasksStrict = originalStrict
+		db.Close()
+	}
+}
+
+func TestGetUserTasksSharePoolQueryFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	mock.ExpectQuery("SELECT id, onboarding_completed, onboarding_points, COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "onboarding_completed", "onboarding_points", "onboarding_amount"}).
+			AddRow(1, false, 0, 0.0))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount_usd\\), 0\\), COALESCE").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-CampaignWeek), time.Now().Add(3*CampaignWeek), true, false))
+	mock.ExpectQuery("SELECT MAX\\(timestamp\\) FROM points_history").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+
+	tasks, err := GetUserTasks("0x1234567890123456789012345678901234567890")
+	assert.NoError(t, err)
+	assert.Equal(t, true, tasks["partial"])
+	assert.Nil(t, tasks["totalPoints"])
+	assert.Equal(t, map[string]interface{}{"unavailable": true, "eligible": true}, tasks["sharePool"])
+	assert.Equal(t, false, tasks["onboarding"].(map[string]interface{})["completed"])
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -78,17 +249,17 @@ func TestGetUserPointsHistory(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
 
 	rows := sqlmock.NewRows([]string{"points", "reason", "timestamp"}).
 		AddRow(100, "Onboarding", time.Now()).
 		AddRow(200, "Weekly Share", time.Now())
 
 	mock.ExpectQuery("SELECT points, reason, timestamp FROM points_history").
-		WithArgs("0x1234567890123456789012345678901234567890").
+		WithArgs("0x1234567890123456789012345678901234567890", 20, 0).
 		WillReturnRows(rows)
 
-	history, err := GetUserPointsHistory("0x1234567890123456789012345678901234567890")
+	history, err := GetUserPointsHistory("0x1234567890123456789012345678901234567890", Pagination{Limit: 20})
 	assert.NoError(t, err)
 	assert.Len(t, history, 2)
 	assert.Equal(t, 100, history[0]["points"])
@@ -102,21 +273,21 @@ func TestAwardOnboardingPoints(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
 
 	mock.ExpectBegin()
 
-	mock.ExpectExec("UPDATE users SET onboarding_completed = true, onboarding_points = 100").
-		WithArgs(1).
+	mock.ExpectExec("UPDATE users SET onboarding_completed = true").
+		WithArgs(1, 150).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectExec("INSERT INTO points_history").
-		WithArgs(1, 100, "Onboarding task completed", sqlmock.AnyArg()).
+		WithArgs(1, 150, "Onboarding task completed", sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectCommit()
 
-	err = AwardOnboardingPoints(1)
+	err = AwardOnboardingPoints(1, 150)
 	assert.NoError(t, err)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -131,17 +302,63 @@ func TestSetCampaignConfig(t *testing.T) {
 	}
 	defer db.Close()
 
-	DB = db
+	SetDB(db)
 
 	startTime := time.Now()
 	endTime := startTime.Add(4 * 7 * 24 * time.Hour)
 
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK TABLE campaign_config").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, start_time, end_time FROM campaign_config WHERE is_active = true").
+		WithArgs(startTime, endTime).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start_time", "end_time"}))
 	mock.ExpectExec("INSERT INTO campaign_config").
 		WithArgs(startTime, endTime, true).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	err = SetCampaignConfig(startTime)
 	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetCampaignConfigOverlap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	activeStart := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	activeEnd := activeStart.Add(CampaignWeeks * CampaignWeek)
+	startTime := activeEnd.Add(-CampaignWeek)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK TABLE campaign_config").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, start_time, end_time FROM campaign_config WHERE is_active = true").
+		WithArgs(startTime, startTime.Add(CampaignWeeks*CampaignWeek)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start_time", "end_time"}).AddRow(7, activeStart, activeEnd))
+	mock.ExpectRollback()
+
+	err = SetCampaignConfig(startTime)
+
+	var conflict *CampaignConflictError
+	if assert.ErrorAs(t, err, &conflict) {
+		assert.Equal(t, 7, conflict.ID)
+		assert.Equal(t, activeStart, conflict.StartTime)
+		assert.Equal(t, activeEnd, conflict.EndTime)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
 func TestCalculateSwapVolume(t *testing.T) {
@@ -161,12 +378,15 @@ func TestProcessSwapEvents(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	DB = db
+	SetDB(db)
+
+	// Mock the campaign config lookup in ProcessSwapEvents
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true, false))
 
 	// Set up mock expectations for RecordSwap
-	dbMock.ExpectQuery("SELECT id, start_time, end_time, is_active FROM campaign_config").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "start_time", "end_time", "is_active"}).
-			AddRow(1, time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true))
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-7*24*time.Hour), time.Now().Add(21*24*time.Hour), true, false))
 
 	dbMock.ExpectQuery("INSERT INTO users").
 		WithArgs("0x1234567890123456789012345678901234567890").
@@ -174,24 +394,27 @@ func TestProcessSwapEvents(t *testing.T) {
 
 	dbMock.ExpectBegin()
 	dbMock.ExpectExec("INSERT INTO swap_events").
-		WithArgs(1, "0xabcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890", 2000.0, sqlmock.AnyArg()).
+		WithArgs(1, "0xabcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890", 2000.0, sqlmock.AnyArg(), uint64(12345), uint(0)).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	dbMock.ExpectQuery("SELECT onboarding_completed FROM users").
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"onboarding_completed"}).AddRow(false))
-
 	dbMock.ExpectExec("UPDATE users SET onboarding_completed").
-		WithArgs(1).
+		WithArgs(1, OnboardingPoints).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Update the mock expectation for points_history insertion
-	dbMock.ExpectExec("INSERT INTO points_history \\(user_id, points, reason, timestamp\\) VALUES \\(\\$1, 100, 'Onboarding task completed', \\$2\\)").
-		WithArgs(1, sqlmock.AnyArg()).
+	dbMock.ExpectExec("INSERT INTO points_history \\(user_id, points, reason, timestamp\\) VALUES \\(\\$1, \\$2, 'Onboarding task completed', \\$3\\)").
+		WithArgs(1, OnboardingPoints, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	dbMock.ExpectCommit()
 
+	// One audit row records the reserves, USD value and points used for the swap
+	dbMock.ExpectExec("INSERT INTO audit_swap_processing").
+		WithArgs("0xabcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890", uint64(12345), uint(0),
+			"0x1234567890123456789012345678901234567890", "100000000000000000000", "200000000000",
+			"reserves", 2000.0, 100, SwapRuleVersion).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	// Set up mock Ethereum client
 	mockClient := new(MockEthereumClient)
 	Client = mockClient
@@ -200,16 +423,28 @@ func TestProcessSwapEvents(t *testing.T) {
 	ethPrice := big.NewInt(2000e8) // 2000 USD per ETH, with 8 decimal places
 	mockClient.On("CallContract", mock.Anything, mock.MatchedBy(func(call ethereum.CallMsg) bool {
 		return call.To.Hex() == ChainlinkETHUSDAddress
-	}), mock.Anything).Return(
-		append(
-			make([]byte, 32), // roundId
-			append(
-				common.LeftPadBytes(ethPrice.Bytes(), 32), // price
-				make([]byte, 32*3)...,                     // startedAt, updatedAt, answeredInRound
-			)...,
-		),
-		nil,
-	)
+	}), mock.Anything).Return(latestRoundData(ethPrice, time.Now()), nil)
+
+	// Stub the pool reserves at 100 WETH / 200,000 USDC, a pool price of 2000 USD per ETH
+	originalGetPoolReserves := getPoolReservesWrapper
+	defer func() { getPoolReservesWrapper = originalGetPoolReserves }()
+	getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error) {
+		reserve0 := new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18))
+		return reserve0, big.NewInt(200000e6), nil
+	}
+
+	// Mock the pair token, decimals and symbol lookups
+	tokenMetadata = newTokenCache()
+	mockPairDecimals(mockClient, common.HexToAddress(UniswapV2PairAddress),
+		common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"),
+		common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), 18, 6)
+	mockTokenSymbols(mockClient, map[common.Address]string{
+		common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"): "WETH",
+		common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"): "USDC",
+	})
+
+	// Mock the header of the swap's block, which gives the swap its timestamp
+	mockClient.On("HeaderByNumber", mock.Anything, big.NewInt(12345)).Return(&types.Header{Number: big.NewInt(12345), Time: uint64(time.Now().Unix())}, nil)
 
 	// Create a sample Swap event log
 	senderAddress := common.HexToAddress("0x1234567890123456789012345678901234567890")
@@ -245,7 +480,8 @@ func TestProcessSwapEvents(t *testing.T) {
 
 	logs := []types.Log{sampleLog}
 
-	swapEvents := ProcessSwapEvents(logs)
+	swapEvents, err := ProcessSwapEvents(logs)
+	assert.NoError(t, err)
 
 	assert.Len(t, swapEvents, 1, "Expected 1 swap event to be processed")
 	if len(swapEvents) > 0 {
@@ -255,6 +491,12 @@ func TestProcessSwapEvents(t *testing.T) {
 		assert.Equal(t, 0, amount0Out.Cmp(swapEvents[0].Amount0Out), "Amount0Out should be equal")
 		assert.Equal(t, 0, amount1Out.Cmp(swapEvents[0].Amount1Out), "Amount1Out should be equal")
 		assert.Equal(t, recipientAddress, swapEvents[0].To)
+		if assert.NotNil(t, swapEvents[0].Pair) {
+			assert.Equal(t, "WETH/USDC", swapEvents[0].Pair.Label)
+		}
+		encoded, err := json.Marshal(swapEvents[0])
+		assert.NoError(t, err)
+		assert.Contains(t, string(encoded), `"label":"WETH/USDC"`)
 
 		// Check if USDValue is set and correct
 		assert.NotNil(t, swapEvents[0].USDValue, "USDValue should not be nil")
@@ -272,3 +514,557 @@ func TestProcessSwapEvents(t *testing.T) {
 		t.Errorf("there were unfulfilled database expectations: %s", err)
 	}
 }
+
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	server := newHTTPServer(":8080", nil)
+	assert.Equal(t, defaultReadHeaderTimeout, server.ReadHeaderTimeout)
+	assert.Equal(t, defaultReadTimeout, server.ReadTimeout)
+	assert.Equal(t, defaultWriteTimeout, server.WriteTimeout)
+	assert.Equal(t, defaultIdleTimeout, server.IdleTimeout)
+
+	t.Setenv("HTTP_WRITE_TIMEOUT", "5m")
+	t.Setenv("HTTP_READ_TIMEOUT", "not-a-duration")
+	server = newHTTPServer(":8080", nil)
+	assert.Equal(t, 5*time.Minute, server.WriteTimeout)
+	assert.Equal(t, defaultReadTimeout, server.ReadTimeout)
+}
+
+func TestGetUserPointsHistoryWithChecksummedAddress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// A checksummed address from a client is looked up by its lowercase form
+	mock.ExpectQuery("SELECT points, reason, timestamp FROM points_history").
+		WithArgs("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"points", "reason", "timestamp"}).
+			AddRow(100, "Onboarding task completed", time.Now()))
+
+	history, err := GetUserPointsHistory("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", Pagination{Limit: 20})
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	lower := "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+
+	assert.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", checksumAddress(lower))
+	assert.Equal(t, common.HexToAddress(lower).Hex(), checksumAddress(lower))
+	assert.Equal(t, lower, normalizeAddress(checksumAddress(lower)))
+	assert.Equal(t, "not-an-address", checksumAddress("not-an-address"))
+}
+
+// newSwapLog builds a Swap event log for a WETH-in, USDC-out swap
+func newSwapLog(sender common.Address, txHash common.Hash, blockNumber uint64, amount0In, amount1Out *big.Int) types.Log {
+	data := common.LeftPadBytes(amount0In.Bytes(), 32)
+	data = append(data, make([]byte, 32)...) // amount1In
+	data = append(data, make([]byte, 32)...) // amount0Out
+	data = append(data, common.LeftPadBytes(amount1Out.Bytes(), 32)...)
+
+	return types.Log{
+		Address: common.HexToAddress(UniswapV2PairAddress),
+		Topics: []common.Hash{
+			crypto.Keccak256Hash(SwapEventSignature),
+			common.BytesToHash(sender.Bytes()),
+			common.BytesToHash(common.HexToAddress("0x0987654321098765432109876543210987654321").Bytes()),
+		},
+		Data:        data,
+		BlockNumber: blockNumber,
+		TxHash:      txHash,
+	}
+}
+
+// mockSwapPricing stubs the Chainlink price, pair decimals, pool reserves and block headers used
+// by ProcessSwapEvents. Blocks are mined now unless a test set up their headers first.
+func mockSwapPricing(t *testing.T, mockClient *MockEthereumClient) {
+	mockClient.On("HeaderByNumber", mock.Anything, mock.Anything).Return(&types.Header{Time: uint64(time.Now().Unix())}, nil)
+
+	ethPrice := big.NewInt(2000e8)
+	mockClient.On("CallContract", mock.Anything, mock.MatchedBy(func(call ethereum.CallMsg) bool {
+		return call.To.Hex() == ChainlinkETHUSDAddress
+	}), mock.Anything).Return(latestRoundData(ethPrice, time.Now()), nil)
+
+	tokenMetadata = newTokenCache()
+	mockPairDecimals(mockClient, common.HexToAddress(UniswapV2PairAddress),
+		common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"),
+		common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), 18, 6)
+	mockTokenSymbols(mockClient, map[common.Address]string{
+		common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"): "WETH",
+		common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"): "USDC",
+	})
+
+	originalGetPoolReserves := getPoolReservesWrapper
+	t.Cleanup(func() { getPoolReservesWrapper = originalGetPoolReserves })
+	getPoolReservesWrapper = func(blockNumber uint64) (*big.Int, *big.Int, error) {
+		return new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18)), big.NewInt(200000e6), nil
+	}
+}
+
+func TestProcessSwapEventsWithoutEthereumPrice(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	SetDB(db)
+
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-24*time.Hour), time.Now().Add(27*24*time.Hour), true, false))
+	dbMock.ExpectExec("INSERT INTO audit_swap_processing").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// The Chainlink call fails outright rather than returning a stale price
+	originalBreaker := ethPriceBreaker
+	defer func() { ethPriceBreaker = originalBreaker }()
+	ethPriceBreaker = newPriceBreaker(0, time.Minute)
+
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	mockClient.On("CallContract", mock.Anything, mock.MatchedBy(func(call ethereum.CallMsg) bool {
+		return call.To.Hex() == ChainlinkETHUSDAddress
+	}), mock.Anything).Return([]byte(nil), errors.New("503 Service Unavailable"))
+	mockSwapPricing(t, mockClient)
+
+	recorder := newFakeSwapRecorder()
+	originalRecordSwap := recordSwapWrapper
+	defer func() { recordSwapWrapper = originalRecordSwap }()
+	recordSwapWrapper = recorder.record
+
+	sender := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	swapEvents, err := ProcessSwapEvents([]types.Log{newSwapLog(sender, common.HexToHash("0x01"), 100, big.NewInt(1e18), big.NewInt(2000e6))})
+	assert.NoError(t, err)
+
+	// The swap is still valued from the pool reserves
+	if assert.Len(t, swapEvents, 1) {
+		usdValue, _ := swapEvents[0].USDValue.Float64()
+		assert.InDelta(t, 2000.0, usdValue, 0.01)
+	}
+	assert.Equal(t, []string{common.HexToHash("0x01").Hex()}, recorder.order[sender.Hex()])
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+func TestProcessSwapEventsRespectsCampaignStartBlock(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	SetDB(db)
+
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	mockSwapPricing(t, mockClient)
+
+	campaignStart := time.Now().Add(-24 * time.Hour)
+	campaignEnd := campaignStart.Add(4 * 7 * 24 * time.Hour)
+
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRowsWithBlocks(campaignStart, campaignEnd, true, false, 12345, 0))
+
+	// Only the swap at the start block is recorded
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRowsWithBlocks(campaignStart, campaignEnd, true, false, 12345, 0))
+	dbMock.ExpectQuery("INSERT INTO users").
+		WithArgs("0x1234567890123456789012345678901234567890").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec("INSERT INTO swap_events").
+		WithArgs(1, "0x00000000000000000000000000000000000000000000000000000000000000b2", 20.0, sqlmock.AnyArg(), uint64(12345), uint(0)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectCommit()
+	dbMock.ExpectExec("INSERT INTO audit_swap_processing").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	logs := []types.Log{
+		newSwapLog(sender, common.HexToHash("0xb1"), 12344, big.NewInt(1e16), big.NewInt(20e6)),
+		newSwapLog(sender, common.HexToHash("0xb2"), 12345, big.NewInt(1e16), big.NewInt(20e6)),
+	}
+
+	swapEvents, err := ProcessSwapEvents(logs)
+	assert.NoError(t, err)
+
+	assert.Len(t, swapEvents, 1)
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+func TestConfirmedBlockRange(t *testing.T) {
+	from, to, ok := confirmedBlockRange(1000, 5, 100)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(895), from)
+	assert.Equal(t, uint64(995), to)
+
+	from, to, ok = confirmedBlockRange(50, 5, 100)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), from)
+	assert.Equal(t, uint64(45), to)
+
+	_, _, ok = confirmedBlockRange(3, 5, 100)
+	assert.False(t, ok)
+}
+
+func TestProcessLatestSwapsDefersUnconfirmedBlocks(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	originalConfirmations := SwapConfirmations
+	defer func() { SwapConfirmations = originalConfirmations }()
+	SwapConfirmations = 5
+
+	// The five newest blocks are still within the confirmation window, so the scan stops at 995
+	mockClient.On("BlockNumber", mock.Anything).Return(uint64(1000), nil).Once()
+	mockClient.On("FilterLogs", mock.Anything, mock.MatchedBy(func(q ethereum.FilterQuery) bool {
+		return q.FromBlock.Uint64() == 895 && q.ToBlock.Uint64() == 995
+	})).Return([]types.Log{}, assert.AnError).Once()
+
+	processLatestSwaps()
+
+	// Nothing is fetched until at least one block is confirmed
+	mockClient.On("BlockNumber", mock.Anything).Return(uint64(3), nil).Once()
+
+	processLatestSwaps()
+
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "FilterLogs", 1)
+}
+
+func TestProcessLatestSwapsRecordsLag(t *testing.T) {
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+
+	originalConfirmations := SwapConfirmations
+	originalProcessed := lastProcessedBlock.Load()
+	defer func() {
+		SwapConfirmations = originalConfirmations
+		lastProcessedBlock.Store(originalProcessed)
+	}()
+	SwapConfirmations = 5
+
+	// The last batch ended at block 900 and the fetch for this cycle fails, so the lag is not reduced
+	lastProcessedBlock.Store(900)
+	mockClient.On("BlockNumber", mock.Anything).Return(uint64(1000), nil).Once()
+	mockClient.On("FilterLogs", mock.Anything, mock.Anything).Return([]types.Log{}, assert.AnError).Once()
+
+	processLatestSwaps()
+
+	assert.Equal(t, uint64(100), swapProcessorLag.Load())
+	assert.Equal(t, uint64(900), lastProcessedBlock.Load())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "# TYPE trading_ace_swap_processor_lag_blocks gauge\ntrading_ace_swap_processor_lag_blocks 100\n")
+	assert.Contains(t, w.Body.String(), "trading_ace_chain_head_block 1000\n")
+	mockClient.AssertExpectations(t)
+}
+
+func TestProcessLatestSwapsStoresBlockOnlyOnSuccess(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	SetDB(db)
+
+	mockClient := new(MockEthereumClient)
+	Client = mockClient
+	mockSwapPricing(t, mockClient)
+
+	originalConfirmations := SwapConfirmations
+	originalProcessed := lastProcessedBlock.Load()
+	originalRecordSwap := recordSwapWrapper
+	defer func() {
+		SwapConfirmations = originalConfirmations
+		lastProcessedBlock.Store(originalProcessed)
+		recordSwapWrapper = originalRecordSwap
+	}()
+	SwapConfirmations = 5
+	lastProcessedBlock.Store(900)
+
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	mockClient.On("BlockNumber", mock.Anything).Return(uint64(1000), nil)
+	mockClient.On("FilterLogs", mock.Anything, mock.Anything).
+		Return([]types.Log{newSwapLog(sender, common.HexToHash("0x01"), 990, big.NewInt(1e16), big.NewInt(20e6))}, nil)
+
+	// Recording the swap fails, so the batch is retried and the block is not marked processed
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-24*time.Hour), time.Now().Add(27*24*time.Hour), true, false))
+	recordSwapWrapper = func(address string, amountUSD float64, txHash string, blockNumber uint64, logIndex uint, timestamp time.Time) (int, bool, error) {
+		return 0, false, errors.New("connection reset by peer")
+	}
+
+	processLatestSwaps()
+	assert.Equal(t, uint64(900), lastProcessedBlock.Load())
+
+	// The retry succeeds
+	dbMock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(time.Now().Add(-24*time.Hour), time.Now().Add(27*24*time.Hour), true, false))
+	dbMock.ExpectExec("INSERT INTO audit_swap_processing").WillReturnResult(sqlmock.NewResult(1, 1))
+	recordSwapWrapper = newFakeSwapRecorder().record
+
+	processLatestSwaps()
+	assert.Equal(t, uint64(995), lastProcessedBlock.Load())
+
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+func TestCheckCampaignEnd(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	// The final distribution is covered by TestCheckCampaignEndRunsFinalDistribution
+	originalFinal := finalDistributionOnEnd
+	defer func() { finalDistributionOnEnd = originalFinal }()
+	finalDistributionOnEnd = false
+
+	now := time.Now()
+
+	// A campaign past its end time is ended on the next tick
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(now.Add(-29*24*time.Hour), now.Add(-time.Minute), true, false))
+	mock.ExpectExec("UPDATE campaign_config SET is_active = false").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Equal(t, time.Hour, checkCampaignEnd(now, time.Hour))
+
+	// A campaign ending before the next interval is checked again exactly at its end time
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(now.Add(-27*24*time.Hour), now.Add(10*time.Minute), true, false))
+	assert.Equal(t, 10*time.Minute, checkCampaignEnd(now, time.Hour))
+
+	// An already inactive campaign is left alone
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(now.Add(-40*24*time.Hour), now.Add(-12*24*time.Hour), false, false))
+	assert.Equal(t, time.Hour, checkCampaignEnd(now, time.Hour))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNextSharePoolRun(t *testing.T) {
+	start := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC) // a Wednesday
+	config := CampaignConfig{StartTime: start, EndTime: start.Add(3*CampaignWeek + 2*24*time.Hour)}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+		ok   bool
+	}{
+		{"before the campaign", start.Add(-time.Hour), start.Add(CampaignWeek), true},
+		{"during week 1", start.Add(time.Hour), start.Add(CampaignWeek), true},
+		{"at a week boundary", start.Add(CampaignWeek), start.Add(2 * CampaignWeek), true},
+		{"partial last week", start.Add(3*CampaignWeek + time.Hour), config.EndTime, true},
+		{"after the campaign", config.EndTime, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, ok := nextSharePoolRun(config, tt.now)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, next)
+		})
+	}
+}
+
+func TestCheckCampaignEndRunsFinalDistribution(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	SetDB(db)
+
+	originalFinal := finalDistributionOnEnd
+	defer func() { finalDistributionOnEnd = originalFinal }()
+	finalDistributionOnEnd = true
+
+	// The campaign ends 3 days into week 3, before the next weekly run would pay that week out
+	now := time.Now()
+	start := now.Add(-2*CampaignWeek - 3*24*time.Hour)
+	end := now.Add(-time.Minute)
+	weekStart := start.Add(2 * CampaignWeek)
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(start, end, true, false))
+	mock.ExpectExec("UPDATE campaign_config SET is_active = false").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectQuery(campaignConfigQuery).
+		WillReturnRows(campaignConfigRows(start, end, false, false))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(distributionLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(end).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT COALESCE").
+		WithArgs(weekStart, end).
+		WillReturnRows(sqlmock.NewRows([]string{"total_volume"}).AddRow(100.0))
+	mock.ExpectQuery("SELECT u.id, u.address, COALESCE").
+		WithArgs(weekStart, end).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "volume"}).AddRow(1, "0x1234", 100.0))
+	mock.ExpectExec("INSERT INTO points_history").
+		WithArgs(1, WeeklyPoolPoints, "Weekly Share Pool Task", end).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO leaderboard_snapshots").
+		WithArgs(1, 3, end).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE campaign_config SET is_active = false WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.Equal(t, time.Hour, checkCampaignEnd(now, time.Hour))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunSwapProcessorFinishesBatchOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	batchStarted := make(chan struct{})
+	var batches, completed atomic.Int32
+	processBatch := func() {
+		if batches.Add(1) == 1 {
+			close(batchStarted)
+		}
+		time.Sleep(50 * time.Millisecond)
+		completed.Add(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSwapProcessor(ctx, time.Hour, processBatch)
+	}()
+
+	<-batchStarted
+	cancel()
+
+	assert.True(t, waitForDrain(done, time.Second), "processor should return within the drain timeout")
+	assert.Equal(t, int32(1), batches.Load())
+	assert.Equal(t, int32(1), completed.Load(), "the in-flight batch should run to completion")
+}
+
+func TestWaitForDrainTimeout(t *testing.T) {
+	assert.False(t, waitForDrain(make(chan struct{}), 10*time.Millisecond))
+}
+
+// fakeSwapRecorder stands in for RecordSwap, onboarding each sender on their first qualifying swap
+type fakeSwapRecorder struct {
+	mu        sync.Mutex
+	onboarded map[string]bool
+	points    map[string]int
+	order     map[string][]string
+}
+
+func newFakeSwapRecorder() *fakeSwapRecorder {
+	return &fakeSwapRecorder{onboarded: map[string]bool{}, points: map[string]int{}, order: map[string][]string{}}
+}
+
+func (f *fakeSwapRecorder) record(address string, amountUSD float64, txHash string, blockNumber uint64, logIndex uint, timestamp time.Time) (int, bool, error) {
+	time.Sleep(time.Millisecond) // widen the window for interleaving between senders
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.order[address] = append(f.order[address], txHash)
+	if amountUSD >= OnboardingThresholdUSD && !f.onboarded[address] {
+		f.onboarded[address] = true
+		f.points[address] += OnboardingPoints
+		return OnboardingPoints, true, nil
+	}
+	return 0, true, nil
+}
+
+func TestProcessSwapEventsConcurrentMatchesSequential(t *testing.T) {
+	senderA := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	senderB := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	senderC := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+
+	small, smallOut := big.NewInt(1e16), big.NewInt(20e6)   // $20
+	large, largeOut := big.NewInt(1e18), big.NewInt(2000e6) // $2000
+
+	logs := []types.Log{
+		newSwapLog(senderA, common.HexToHash("0x01"), 100, small, smallOut),
+		newSwapLog(senderB, common.HexToHash("0x02"), 100, large, largeOut),
+		newSwapLog(senderA, common.HexToHash("0x03"), 101, large, largeOut),
+		newSwapLog(senderC, common.HexToHash("0x04"), 101, small, smallOut),
+		newSwapLog(senderB, common.HexToHash("0x05"), 102, large, largeOut),
+		newSwapLog(senderA, common.HexToHash("0x06"), 102, large, largeOut),
+		newSwapLog(senderC, common.HexToHash("0x07"), 103, large, largeOut),
+	}
+
+	run := func(workers int) ([]*SwapEvent, *fakeSwapRecorder) {
+		db, dbMock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		SetDB(db)
+
+		dbMock.ExpectQuery(campaignConfigQuery).
+			WillReturnRows(campaignConfigRows(time.Now().Add(-24*time.Hour), time.Now().Add(27*24*time.Hour), true, false))
+		for range logs {
+			dbMock.ExpectExec("INSERT INTO audit_swap_processing").WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+
+		mockClient := new(MockEthereumClient)
+		Client = mockClient
+		mockSwapPricing(t, mockClient)
+
+		recorder := newFakeSwapRecorder()
+		originalRecordSwap := recordSwapWrapper
+		defer func() { recordSwapWrapper = originalRecordSwap }()
+		recordSwapWrapper = recorder.record
+
+		originalWorkers := SwapWorkers
+		defer func() { SwapWorkers = originalWorkers }()
+		SwapWorkers = workers
+
+		swapEvents, err := ProcessSwapEvents(logs)
+		assert.NoError(t, err)
+
+		if err := dbMock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled database expectations: %s", err)
+		}
+		return swapEvents, recorder
+	}
+
+	sequentialEvents, sequential := run(1)
+	concurrentEvents, concurrent := run(4)
+
+	assert.Len(t, sequentialEvents, len(logs))
+	assert.Len(t, concurrentEvents, len(logs))
+	for i := range sequentialEvents {
+		assert.Equal(t, sequentialEvents[i].Sender, concurrentEvents[i].Sender, "events should stay in log order")
+		assert.Equal(t, 0, sequentialEvents[i].USDValue.Cmp(concurrentEvents[i].USDValue))
+	}
+
+	assert.Equal(t, sequential.points, concurrent.points)
+	assert.Equal(t, sequential.order, concurrent.order, "each sender's swaps should be recorded in log order")
+	assert.Equal(t, map[string]int{senderA.Hex(): OnboardingPoints, senderB.Hex(): OnboardingPoints, senderC.Hex(): OnboardingPoints}, concurrent.points)
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS swap_events_transaction_hash_key;
\ No newline at end of file
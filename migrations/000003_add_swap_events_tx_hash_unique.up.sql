@@ -0,0 +1,6 @@
+-- Remove duplicate swap events before enforcing uniqueness
+DELETE FROM swap_events a
+USING swap_events b
+WHERE a.id > b.id AND a.transaction_hash = b.transaction_hash;
+
+CREATE UNIQUE INDEX IF NOT EXISTS swap_events_transaction_hash_key ON swap_events (transaction_hash);
@@ -0,0 +1 @@
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS paused;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS paused BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
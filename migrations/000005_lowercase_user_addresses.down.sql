@@ -0,0 +1,2 @@
+-- Original address casing cannot be restored and merged users are not split again; lowercase addresses remain valid
+SELECT 1;
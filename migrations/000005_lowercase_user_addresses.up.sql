@@ -0,0 +1,31 @@
+-- Addresses are stored lowercase so lookups are case-insensitive
+
+-- Merge users whose addresses differ only in case into the earliest row first,
+-- otherwise lowercasing them would violate the unique address constraint
+CREATE TEMP TABLE user_address_merges AS
+SELECT id, MIN(id) OVER (PARTITION BY LOWER(address)) AS keep_id
+FROM users;
+
+DELETE FROM user_address_merges WHERE id = keep_id;
+
+UPDATE users u
+SET onboarding_completed = COALESCE(u.onboarding_completed, FALSE) OR merged.onboarding_completed,
+    onboarding_points = COALESCE(u.onboarding_points, 0) + merged.onboarding_points
+FROM (
+    SELECT m.keep_id,
+           BOOL_OR(COALESCE(d.onboarding_completed, FALSE)) AS onboarding_completed,
+           SUM(COALESCE(d.onboarding_points, 0)) AS onboarding_points
+    FROM user_address_merges m
+    JOIN users d ON d.id = m.id
+    GROUP BY m.keep_id
+) merged
+WHERE u.id = merged.keep_id;
+
+UPDATE swap_events s SET user_id = m.keep_id FROM user_address_merges m WHERE s.user_id = m.id;
+UPDATE points_history p SET user_id = m.keep_id FROM user_address_merges m WHERE p.user_id = m.id;
+
+DELETE FROM users u USING user_address_merges m WHERE u.id = m.id;
+
+DROP TABLE user_address_merges;
+
+UPDATE users SET address = LOWER(address) WHERE address <> LOWER(address);
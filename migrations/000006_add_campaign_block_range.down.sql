@@ -0,0 +1,2 @@
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS end_block;
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS start_block;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS start_block BIGINT;
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS end_block BIGINT;
\ No newline at end of file
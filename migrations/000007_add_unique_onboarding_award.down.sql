@@ -0,0 +1 @@
+DROP INDEX IF EXISTS points_history_onboarding_once;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- Keep only the earliest onboarding award per user before enforcing uniqueness
+DELETE FROM points_history a
+USING points_history b
+WHERE a.reason = 'Onboarding task completed'
+  AND b.reason = 'Onboarding task completed'
+  AND a.user_id = b.user_id
+  AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS points_history_onboarding_once
+ON points_history (user_id)
+WHERE reason = 'Onboarding task completed';
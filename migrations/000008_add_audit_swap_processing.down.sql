@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS audit_swap_processing;
+DROP FUNCTION IF EXISTS audit_swap_processing_immutable();
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS audit_swap_processing (
+    id SERIAL PRIMARY KEY,
+    transaction_hash VARCHAR(66) NOT NULL,
+    block_number BIGINT NOT NULL,
+    log_index INT NOT NULL,
+    sender VARCHAR(42) NOT NULL,
+    reserve0 NUMERIC(78, 0),
+    reserve1 NUMERIC(78, 0),
+    price_source VARCHAR(16) NOT NULL,
+    usd_value NUMERIC(20, 2) NOT NULL,
+    points INT NOT NULL,
+    rule_version VARCHAR(32) NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_swap_processing_tx_hash
+ON audit_swap_processing (transaction_hash);
+
+-- Audit rows are append-only
+CREATE OR REPLACE FUNCTION audit_swap_processing_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_swap_processing rows cannot be modified';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_swap_processing_immutable
+BEFORE UPDATE OR DELETE ON audit_swap_processing
+FOR EACH ROW EXECUTE FUNCTION audit_swap_processing_immutable();
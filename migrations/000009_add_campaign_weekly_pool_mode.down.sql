@@ -0,0 +1,3 @@
+ALTER TABLE campaign_config DROP CONSTRAINT IF EXISTS campaign_config_weekly_pool_mode_check;
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS weekly_pool_fraction;
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS weekly_pool_mode;
@@ -0,0 +1,5 @@
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS weekly_pool_mode VARCHAR(20) NOT NULL DEFAULT 'fixed';
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS weekly_pool_fraction NUMERIC(20, 10) NOT NULL DEFAULT 0;
+
+ALTER TABLE campaign_config ADD CONSTRAINT campaign_config_weekly_pool_mode_check
+    CHECK (weekly_pool_mode IN ('fixed', 'volume_fraction'));
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS swap_events_block_number;
+ALTER TABLE swap_events DROP COLUMN IF EXISTS block_number;
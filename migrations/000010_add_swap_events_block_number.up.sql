@@ -0,0 +1,4 @@
+-- Swaps recorded before this migration have no block number
+ALTER TABLE swap_events ADD COLUMN IF NOT EXISTS block_number BIGINT;
+
+CREATE INDEX IF NOT EXISTS swap_events_block_number ON swap_events (block_number);
@@ -0,0 +1 @@
+ALTER TABLE swap_events DROP COLUMN IF EXISTS log_index;
@@ -0,0 +1,2 @@
+-- Swaps recorded before this migration have no log index
+ALTER TABLE swap_events ADD COLUMN IF NOT EXISTS log_index INT;
@@ -0,0 +1,2 @@
+-- Normalized reasons cannot be mapped back to the variants they replaced
+SELECT 1;
@@ -0,0 +1,12 @@
+-- Earlier versions wrote several variants of the same points_history reasons
+
+-- Keep a single onboarding award per user so the unique onboarding index still holds
+DELETE FROM points_history a
+USING points_history b
+WHERE a.reason = 'Onboarding'
+  AND b.reason IN ('Onboarding', 'Onboarding task completed')
+  AND a.user_id = b.user_id
+  AND (b.reason = 'Onboarding task completed' OR a.id > b.id);
+
+UPDATE points_history SET reason = 'Onboarding task completed' WHERE reason = 'Onboarding';
+UPDATE points_history SET reason = 'Weekly Share Pool Task' WHERE reason IN ('Weekly Share', 'Weekly Share Pool');
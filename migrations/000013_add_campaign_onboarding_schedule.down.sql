@@ -0,0 +1 @@
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS onboarding_points_schedule;
@@ -0,0 +1,3 @@
+-- Onboarding points by campaign week: element 1 applies to week 1, and so on. Weeks past the
+-- end of the schedule, and campaigns with an empty schedule, award the default 100 points.
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS onboarding_points_schedule INT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,10 @@
+-- The all-time leaderboard as of the end of each distributed campaign week
+CREATE TABLE IF NOT EXISTS leaderboard_snapshots (
+    campaign_id INT NOT NULL REFERENCES campaign_config(id),
+    week INT NOT NULL,
+    rank INT NOT NULL,
+    address VARCHAR(42) NOT NULL,
+    points BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, week, address)
+);
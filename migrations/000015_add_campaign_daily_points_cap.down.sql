@@ -0,0 +1 @@
+ALTER TABLE campaign_config DROP COLUMN IF EXISTS max_points_per_day_per_user;
@@ -0,0 +1,2 @@
+-- Most points a user can earn from swaps per UTC day; 0 disables the cap
+ALTER TABLE campaign_config ADD COLUMN IF NOT EXISTS max_points_per_day_per_user INT NOT NULL DEFAULT 0;
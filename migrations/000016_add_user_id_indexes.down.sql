@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS points_history_user_id;
+DROP INDEX IF EXISTS swap_events_user_id;
@@ -0,0 +1,3 @@
+-- Per-user lookups and counts; points is included so leaderboard totals can be summed from the index
+CREATE INDEX IF NOT EXISTS swap_events_user_id ON swap_events (user_id);
+CREATE INDEX IF NOT EXISTS points_history_user_id ON points_history (user_id) INCLUDE (points);
@@ -0,0 +1,7 @@
+-- Keep the first swap of each transaction before restoring per-transaction uniqueness
+DELETE FROM swap_events a
+USING swap_events b
+WHERE a.id > b.id AND a.transaction_hash = b.transaction_hash;
+
+CREATE UNIQUE INDEX IF NOT EXISTS swap_events_transaction_hash_key ON swap_events (transaction_hash);
+DROP INDEX IF EXISTS swap_events_transaction_hash_log_index_key;
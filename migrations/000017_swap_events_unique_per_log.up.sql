@@ -0,0 +1,4 @@
+-- A transaction can contain several swaps on the pair, so swaps are unique per log rather than
+-- per transaction. Swaps recorded before log indexes were stored keep a NULL log_index.
+CREATE UNIQUE INDEX IF NOT EXISTS swap_events_transaction_hash_log_index_key ON swap_events (transaction_hash, log_index);
+DROP INDEX IF EXISTS swap_events_transaction_hash_key;
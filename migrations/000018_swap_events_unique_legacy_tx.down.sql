@@ -0,0 +1 @@
+DROP INDEX IF EXISTS swap_events_legacy_transaction_hash_key;
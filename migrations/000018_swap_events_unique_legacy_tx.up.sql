@@ -0,0 +1,4 @@
+-- Swaps recorded before log indexes were stored have a NULL log_index, which the
+-- (transaction_hash, log_index) index does not treat as a conflict. They stay unique per
+-- transaction, as they were when they were recorded.
+CREATE UNIQUE INDEX IF NOT EXISTS swap_events_legacy_transaction_hash_key ON swap_events (transaction_hash) WHERE log_index IS NULL;
@@ -0,0 +1,5 @@
+CREATE OR REPLACE FUNCTION audit_swap_processing_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_swap_processing rows cannot be modified';
+END;
+$$ LANGUAGE plpgsql;
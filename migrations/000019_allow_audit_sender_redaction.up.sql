@@ -0,0 +1,15 @@
+-- Audit rows stay append-only, except that a deleted user's sender address may be replaced with
+-- 'redacted' so no personal data is left behind. Every other column must be unchanged.
+CREATE OR REPLACE FUNCTION audit_swap_processing_immutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.sender = 'redacted'
+       AND (NEW.id, NEW.transaction_hash, NEW.block_number, NEW.log_index, NEW.reserve0, NEW.reserve1,
+            NEW.price_source, NEW.usd_value, NEW.points, NEW.rule_version, NEW.processed_at)
+           IS NOT DISTINCT FROM
+           (OLD.id, OLD.transaction_hash, OLD.block_number, OLD.log_index, OLD.reserve0, OLD.reserve1,
+            OLD.price_source, OLD.usd_value, OLD.points, OLD.rule_version, OLD.processed_at) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_swap_processing rows cannot be modified';
+END;
+$$ LANGUAGE plpgsql;
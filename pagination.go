@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PageLimits are the default and largest page size a paginated endpoint accepts
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+// defaultPageLimits bounds every paginated endpoint unless overridden
+var defaultPageLimits = PageLimits{Default: 20, Max: 100}
+
+// leaderboardPageLimits bounds /leaderboard pages, overridable with LEADERBOARD_PAGE_SIZE and
+// LEADERBOARD_MAX_PAGE_SIZE
+var leaderboardPageLimits = pageLimitsFromEnv("LEADERBOARD", defaultPageLimits)
+
+// pageLimitsFromEnv reads <prefix>_PAGE_SIZE and <prefix>_MAX_PAGE_SIZE, falling back to def
+// when they are unset, invalid, or the default is larger than the max
+func pageLimitsFromEnv(prefix string, def PageLimits) PageLimits {
+	limits := PageLimits{
+		Default: int(envUint(prefix+"_PAGE_SIZE", uint64(def.Default))),
+		Max:     int(envUint(prefix+"_MAX_PAGE_SIZE", uint64(def.Max))),
+	}
+	if limits.Default < 1 || limits.Default > limits.Max {
+		LogError("Invalid %s page sizes (default %d, max %d), using %d and %d", prefix, limits.Default, limits.Max, def.Default, def.Max)
+		return def
+	}
+	return limits
+}
+
+// Pagination is the page of results requested with the limit and offset query parameters
+type Pagination struct {
+	Limit  int
+	Offset int
+}
+
+// parsePagination reads limit and offset from the query, using limits.Default when limit is
+// missing. The error describes the invalid parameter and is meant for a 400 response.
+func parsePagination(c *gin.Context, limits PageLimits) (Pagination, error) {
+	page := Pagination{Limit: limits.Default}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > limits.Max {
+			return Pagination{}, fmt.Errorf("limit must be between 1 and %d", limits.Max)
+		}
+		page.Limit = limit
+	}
+	if raw := c.Query("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return Pagination{}, errors.New("offset must be a non-negative integer")
+		}
+		page.Offset = offset
+	}
+	return page, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the Chainlink price circuit breaker, overridable with PRICE_BREAKER_THRESHOLD
+// and PRICE_BREAKER_COOLDOWN
+const (
+	defaultPriceBreakerThreshold = 3
+	defaultPriceBreakerCooldown  = 30 * time.Second
+)
+
+// errPriceCircuitOpen is returned while the breaker is open and no price has been fetched yet
+var errPriceCircuitOpen = errors.New("chainlink price circuit breaker is open")
+
+// PriceQuote is an ETH/USD price with the time Chainlink last updated it
+type PriceQuote struct {
+	Price     *big.Float
+	UpdatedAt time.Time
+	// Cached is true when the quote was served from the last good price instead of the RPC
+	Cached bool
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// priceBreaker stops calling the oracle after threshold consecutive RPC failures. While open it
+// serves the last good price; after cooldown a single probe call is let through, closing the
+// breaker on success and reopening it on failure.
+type priceBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	last      *PriceQuote
+	now       func() time.Time
+}
+
+// newPriceBreaker creates a closed breaker; a threshold of 0 disables it
+func newPriceBreaker(threshold int, cooldown time.Duration) *priceBreaker {
+	return &priceBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+var ethPriceBreaker = newPriceBreaker(defaultPriceBreakerThreshold, defaultPriceBreakerCooldown)
+
+func init() {
+	threshold := defaultPriceBreakerThreshold
+	if value := os.Getenv("PRICE_BREAKER_THRESHOLD"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			LogError("Invalid PRICE_BREAKER_THRESHOLD %q, using %d", value, defaultPriceBreakerThreshold)
+		} else {
+			threshold = n
+		}
+	}
+	ethPriceBreaker = newPriceBreaker(threshold, envDuration("PRICE_BREAKER_COOLDOWN", defaultPriceBreakerCooldown))
+}
+
+// allow reports whether a call may go to the oracle, moving an open breaker to half-open
+// once the cooldown has passed. Only one probe is let through while half-open.
+func (b *priceBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold == 0 {
+		return true
+	}
+
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+	}
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+// record updates the breaker with the outcome of an oracle call. Only RPC failures count;
+// a successful call that returns a stale price still shows the oracle is reachable.
+func (b *priceBreaker) record(quote PriceQuote, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	var ethErr *EthereumError
+	if errors.As(err, &ethErr) {
+		b.failures++
+		if b.threshold > 0 && (b.state == breakerHalfOpen || b.failures >= b.threshold) {
+			if b.state != breakerOpen {
+				LogError("Chainlink price circuit breaker opened after %d consecutive failures, retrying in %s", b.failures, b.cooldown)
+			}
+			b.state = breakerOpen
+			b.openedAt = b.now()
+		}
+		return
+	}
+
+	if b.state != breakerClosed {
+		LogInfo("Chainlink price circuit breaker closed")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	if err == nil {
+		b.last = &quote
+	}
+}
+
+// cached returns the last good price, or an error if there is none or it is older than PriceMaxStaleness
+func (b *priceBreaker) cached() (PriceQuote, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last == nil {
+		return PriceQuote{}, errPriceCircuitOpen
+	}
+	if PriceMaxStaleness > 0 && b.now().Sub(b.last.UpdatedAt) > PriceMaxStaleness {
+		return PriceQuote{}, &StalePriceError{UpdatedAt: b.last.UpdatedAt, MaxAge: PriceMaxStaleness}
+	}
+
+	quote := *b.last
+	quote.Cached = true
+	return quote, nil
+}
+
+// GetEthereumPriceQuote fetches the latest ETH/USD price through the circuit breaker, serving
+// the last good price while the breaker is open
+func GetEthereumPriceQuote() (PriceQuote, error) {
+	if !ethPriceBreaker.allow() {
+		return ethPriceBreaker.cached()
+	}
+
+	quote, err := fetchEthereumPrice()
+	ethPriceBreaker.record(quote, err)
+	return quote, err
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// erc20ABI covers the read-only pair and token functions needed to resolve token decimals and symbols
+const erc20ABI = `[{"constant":true,"inputs":[],"name":"token0","outputs":[{"name":"","type":"address"}],"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"token1","outputs":[{"name":"","type":"address"}],"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"stateMutability":"view","type":"function"}]`
+
+var parsedERC20ABI abi.ABI
+
+func init() {
+	var err error
+	parsedERC20ABI, err = abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PairDecimals holds the decimals of a pair's token0 and token1 and which of them is WETH.
+// The other token is the USD stablecoin swaps are valued in.
+type PairDecimals struct {
+	Token0 uint8
+	Token1 uint8
+	// WETHIndex is 0 when token0 is WETH and 1 when token1 is
+	WETHIndex int
+}
+
+// legs orders a pair's token0 and token1 values as its WETH and stablecoin values
+func (d PairDecimals) legs(value0, value1 *big.Int) (weth, stable *big.Int) {
+	if d.WETHIndex == 1 {
+		return value1, value0
+	}
+	return value0, value1
+}
+
+// wethUnit and stableUnit convert raw WETH and stablecoin amounts to whole tokens
+func (d PairDecimals) wethUnit() *big.Float {
+	if d.WETHIndex == 1 {
+		return tokenUnit(d.Token1)
+	}
+	return tokenUnit(d.Token0)
+}
+
+func (d PairDecimals) stableUnit() *big.Float {
+	if d.WETHIndex == 1 {
+		return tokenUnit(d.Token0)
+	}
+	return tokenUnit(d.Token1)
+}
+
+// tokenCache caches immutable on-chain token metadata
+type tokenCache struct {
+	mu         sync.Mutex
+	decimals   map[common.Address]uint8
+	symbols    map[common.Address]string
+	pairTokens map[common.Address][2]common.Address
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		decimals:   make(map[common.Address]uint8),
+		symbols:    make(map[common.Address]string),
+		pairTokens: make(map[common.Address][2]common.Address),
+	}
+}
+
+var tokenMetadata = newTokenCache()
+
+// GetPairDecimals resolves the decimals of both tokens of a Uniswap V2 pair and which one is
+// WETH, reading token0()/token1() and each token's decimals() on first use
+func GetPairDecimals(pair common.Address) (PairDecimals, error) {
+	tokens, err := getPairTokens(pair)
+	if err != nil {
+		return PairDecimals{}, err
+	}
+
+	wethIndex, err := pairWETHIndex(pair, tokens)
+	if err != nil {
+		return PairDecimals{}, err
+	}
+
+	decimals0, err := getTokenDecimals(tokens[0])
+	if err != nil {
+		return PairDecimals{}, err
+	}
+
+	decimals1, err := getTokenDecimals(tokens[1])
+	if err != nil {
+		return PairDecimals{}, err
+	}
+
+	return PairDecimals{Token0: decimals0, Token1: decimals1, WETHIndex: wethIndex}, nil
+}
+
+// pairWETHIndex returns which of a pair's tokens is WETH
+func pairWETHIndex(pair common.Address, tokens [2]common.Address) (int, error) {
+	weth := common.HexToAddress(WETHAddress)
+	switch {
+	case tokens[0] == weth && tokens[1] != weth:
+		return 0, nil
+	case tokens[1] == weth && tokens[0] != weth:
+		return 1, nil
+	}
+	return 0, fmt.Errorf("pair %s has no single WETH leg: token0 %s, token1 %s", pair.Hex(), tokens[0].Hex(), tokens[1].Hex())
+}
+
+func getPairTokens(pair common.Address) ([2]common.Address, error) {
+	tokenMetadata.mu.Lock()
+	tokens, ok := tokenMetadata.pairTokens[pair]
+	tokenMetadata.mu.Unlock()
+	if ok {
+		return tokens, nil
+	}
+
+	for i, method := range []string{"token0", "token1"} {
+		out, err := callERC20(pair, method)
+		if err != nil {
+			return tokens, err
+		}
+		token, ok := out[0].(common.Address)
+		if !ok {
+			return tokens, fmt.Errorf("unexpected %s result type %T for pair %s", method, out[0], pair.Hex())
+		}
+		tokens[i] = token
+	}
+
+	tokenMetadata.mu.Lock()
+	tokenMetadata.pairTokens[pair] = tokens
+	tokenMetadata.mu.Unlock()
+
+	return tokens, nil
+}
+
+func getTokenDecimals(token common.Address) (uint8, error) {
+	tokenMetadata.mu.Lock()
+	decimals, ok := tokenMetadata.decimals[token]
+	tokenMetadata.mu.Unlock()
+	if ok {
+		return decimals, nil
+	}
+
+	out, err := callERC20(token, "decimals")
+	if err != nil {
+		return 0, err
+	}
+	decimals, ok = out[0].(uint8)
+	if !ok {
+		return 0, fmt.Errorf("unexpected decimals result type %T for token %s", out[0], token.Hex())
+	}
+
+	tokenMetadata.mu.Lock()
+	tokenMetadata.decimals[token] = decimals
+	tokenMetadata.mu.Unlock()
+
+	LogInfo("Resolved decimals for token %s: %d", token.Hex(), decimals)
+	return decimals, nil
+}
+
+// TokenInfo identifies one token of a pair
+type TokenInfo struct {
+	Address string `json:"address"`
+	Symbol  string `json:"symbol"`
+}
+
+// PairMetadata describes a pair's tokens, labelled like "WETH/USDC"
+type PairMetadata struct {
+	Address string    `json:"address"`
+	Label   string    `json:"label"`
+	Token0  TokenInfo `json:"token0"`
+	Token1  TokenInfo `json:"token1"`
+}
+
+// GetPairMetadata resolves the addresses and symbols of a pair's tokens,
+// reading each token's symbol() on first use
+func GetPairMetadata(pair common.Address) (PairMetadata, error) {
+	tokens, err := getPairTokens(pair)
+	if err != nil {
+		return PairMetadata{}, err
+	}
+
+	var infos [2]TokenInfo
+	for i, token := range tokens {
+		symbol, err := getTokenSymbol(token)
+		if err != nil {
+			return PairMetadata{}, err
+		}
+		infos[i] = TokenInfo{Address: token.Hex(), Symbol: symbol}
+	}
+
+	return PairMetadata{
+		Address: pair.Hex(),
+		Label:   infos[0].Symbol + "/" + infos[1].Symbol,
+		Token0:  infos[0],
+		Token1:  infos[1],
+	}, nil
+}
+
+func getTokenSymbol(token common.Address) (string, error) {
+	tokenMetadata.mu.Lock()
+	symbol, ok := tokenMetadata.symbols[token]
+	tokenMetadata.mu.Unlock()
+	if ok {
+		return symbol, nil
+	}
+
+	out, err := callERC20(token, "symbol")
+	if err != nil {
+		return "", err
+	}
+	symbol, ok = out[0].(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected symbol result type %T for token %s", out[0], token.Hex())
+	}
+
+	tokenMetadata.mu.Lock()
+	tokenMetadata.symbols[token] = symbol
+	tokenMetadata.mu.Unlock()
+
+	return symbol, nil
+}
+
+func callERC20(contract common.Address, method string) ([]interface{}, error) {
+	data, err := parsedERC20ABI.Pack(method)
+	if err != nil {
+		return nil, LogErrorf(err, "failed to pack %s call", method)
+	}
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	result, err := Client.CallContract(ctx, ethereum.CallMsg{
+		To:   &contract,
+		Data: data,
+	}, nil)
+	if err != nil {
+		return nil, LogErrorf(&EthereumError{Operation: method, Err: err}, "failed to call %s on %s", method, contract.Hex())
+	}
+
+	out, err := parsedERC20ABI.Unpack(method, result)
+	if err != nil {
+		return nil, LogErrorf(err, "failed to unpack %s result", method)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty %s result from %s", method, contract.Hex())
+	}
+
+	return out, nil
+}
+
+// stablecoinSymbols optionally restricts the tracked pair's non-WETH leg to these token symbols,
+// from the comma-separated STABLECOIN_SYMBOLS, e.g. "USDC,USDT,DAI". Symbols rather than
+// addresses are used so testnet deployments of the same tokens are accepted. When unset, any
+// token is accepted.
+var stablecoinSymbols = parseSymbols(os.Getenv("STABLECOIN_SYMBOLS"))
+
+// parseSymbols splits a comma-separated list of token symbols, ignoring case
+func parseSymbols(list string) []string {
+	var symbols []string
+	for _, symbol := range strings.Split(list, ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// ValidateTrackedPair checks that the tracked pair has exactly one WETH leg, which swap valuation
+// relies on, and that the other leg is one of stablecoinSymbols when they are set. Errors reaching
+// the RPC endpoint are returned as they are, so callers can tell them apart with isFailoverError.
+func ValidateTrackedPair() error {
+	pair := common.HexToAddress(UniswapV2PairAddress)
+	decimals, err := GetPairDecimals(pair)
+	if err != nil {
+		return err
+	}
+	if len(stablecoinSymbols) == 0 {
+		return nil
+	}
+
+	metadata, err := GetPairMetadata(pair)
+	if err != nil {
+		return err
+	}
+	stable := metadata.Token1
+	if decimals.WETHIndex == 1 {
+		stable = metadata.Token0
+	}
+	for _, symbol := range stablecoinSymbols {
+		if strings.ToUpper(stable.Symbol) == symbol {
+			return nil
+		}
+	}
+	return fmt.Errorf("pair %s has no accepted stablecoin leg: %s (%s) is not one of STABLECOIN_SYMBOLS %s",
+		pair.Hex(), stable.Symbol, stable.Address, strings.Join(stablecoinSymbols, ", "))
+}
+
+// tokenUnit returns 10^decimals as a big.Float, the divisor converting raw token amounts to whole tokens
+func tokenUnit(decimals uint8) *big.Float {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	return new(big.Float).SetInt(unit)
+}